@@ -1,22 +1,75 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"github.com/goingfullstack/currencyconverter/server"
-	"log"
+	"log/slog"
+	"os"
 )
 
 func main() {
-	// create a new server
-	s, err := server.New()
+	// a flag for every setting but the secrets, only the flags given are applied
+	settings := server.ConfigSettings()
+	flags := make(map[string]*string)
+	for _, setting := range settings {
+		if setting.Flag == "" {
+			continue
+		}
+
+		usage := fmt.Sprintf("%s (%s)", setting.Usage, setting.Env)
+		if setting.Default != "" {
+			usage = fmt.Sprintf("%s (%s, default %q)", setting.Usage, setting.Env, setting.Default)
+		}
+		flags[setting.Flag] = flag.String(setting.Flag, "", usage)
+	}
+
+	configFile := flag.String("config", os.Getenv(server.ConfigEnvironment), "configuration file, JSON, YAML or TOML ("+server.ConfigEnvironment+")")
+	printConfig := flag.Bool("print-config", false, "print the validated configuration and exit")
+	flag.Parse()
+
+	// layer the defaults, the file, the environment and the flags
+	config := server.DefaultConfig()
+	if err := config.LoadFile(*configFile); err != nil {
+		slog.Error("Error loading configuration", "error", err)
+		os.Exit(2)
+	}
+	config.LoadEnv()
+
+	flag.Visit(func(f *flag.Flag) {
+		for _, setting := range settings {
+			if setting.Flag != "" && setting.Flag == f.Name {
+				config.Set(setting.Key, *flags[f.Name])
+			}
+		}
+	})
+
+	// log structured to stderr, the standard logger included
+	logger, err := config.Logger(os.Stderr)
+	if err != nil {
+		slog.Error("Error creating logger", "error", err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
+	// create a new server, this validates the configuration
+	s, err := server.NewWithConfig(config, logger)
 	if err != nil {
 		// creation failed, print error and exit
-		log.Println("Error creating server:", err)
+		slog.Error("Error creating server", "error", err)
+		os.Exit(2)
+	}
+
+	if *printConfig {
+		config.Print(os.Stdout)
 		return
 	}
 
+	s.PublishVars()
 	err = s.Run() // run the server
 	if err != nil {
 		// running returned error
-		log.Println("Server stopped with error:", err)
+		slog.Error("Server stopped with error", "error", err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	overrideSource = "override" // the source of overridden rates
+)
+
+// a manually set rate, all rates are relative to EUR like the provider data
+type rateOverride struct {
+	Currency string    `json:"currency"`
+	Rate     float64   `json:"rate"`
+	Expires  time.Time `json:"expires,omitempty"`
+	Note     string    `json:"note,omitempty"`
+}
+
+// struct for the override request, the rate can be given relative to
+// another base and the expiry either as a time or a duration
+type overrideRequest struct {
+	Currency     string    `json:"currency"`
+	Rate         float64   `json:"rate"`
+	BaseCurrency string    `json:"base_currency"`
+	Expires      time.Time `json:"expires"`
+	TTL          string    `json:"ttl"`
+	Note         string    `json:"note"`
+}
+
+// returns true if the override has expired at the given time
+func (o rateOverride) expired(now time.Time) bool {
+	return !o.Expires.IsZero() && !now.Before(o.Expires)
+}
+
+// Merges the fetched rates with the active overrides into the currencies
+// used for the responses. Expired overrides are dropped. Must be called
+// while holding the rate lock.
+func (s *Server) mergeRates() {
+	now := time.Now()
+	currencies := make(map[string]float64, len(s.feed)+len(s.overrides))
+	for name, rate := range s.feed {
+		currencies[name] = rate
+	}
+
+	for name, o := range s.overrides {
+		if o.expired(now) {
+			delete(s.overrides, name)
+			s.logger.Info("Override expired", "currency", name)
+			continue
+		}
+
+		currencies[name] = o.Rate
+	}
+
+	// the change time only moves with the rates, a fetch of the same
+	// rates leaves it
+	s.currencies = currencies
+	if version := rateVersion(s.lastUpdateTime, currencies); version != s.version {
+		s.version, s.ratesChanged = version, now
+	}
+}
+
+// returns the source of the rate for the given currency, must be called
+// while holding the rate lock
+func (s *Server) rateSource(name string) string {
+	if _, found := s.overrides[name]; found {
+		return overrideSource
+	}
+
+	return s.provider
+}
+
+// returns the overridden currencies among the given names, sorted, must be
+// called while holding the rate lock
+func (s *Server) overridden(names ...string) (result []string) {
+	for _, name := range names {
+		if _, found := s.overrides[name]; found && !contains(result, name) {
+			result = append(result, name)
+		}
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// Verifies the admin token of the request. Responds with not found if the
+// admin API is disabled and unauthorized on a wrong token.
+func (s *Server) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.adminToken == "" {
+		http.NotFound(w, r)
+		return false
+	}
+
+	token := []byte("Bearer " + s.adminToken)
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), token) != 1 {
+		http.Error(w, "", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// Handles the rate overrides (/admin/overrides)
+func (s *Server) overridesHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		// GET - list the active overrides
+		s.rateMutex.RLock()
+		list := s.listOverrides()
+		s.rateMutex.RUnlock()
+		s.respondJson(w, list, nil)
+	} else if r.Method == http.MethodPost {
+		// POST - parse and set the override
+		var req overrideRequest
+		err := s.getJsonRequest(r, &req)
+		if err != nil {
+			requestLogger(r).Warn("Invalid request", "error", err)
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+
+		o, err := s.setOverride(req)
+		if err != nil {
+			requestLogger(r).Warn("Override rejected", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.respondJson(w, o, nil)
+	} else if r.Method == http.MethodDelete {
+		// DELETE - clear a single override or all of them
+		s.clearOverride(r.URL.Query().Get("currency"))
+	} else {
+		http.Error(w, "", http.StatusBadRequest)
+	}
+}
+
+// returns the active overrides sorted by currency, must be called while
+// holding the rate lock
+func (s *Server) listOverrides() (list []rateOverride) {
+	list = []rateOverride{}
+	for _, o := range s.overrides {
+		list = append(list, o)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Currency < list[j].Currency })
+	return list
+}
+
+// Validates the request and sets the override. An expiring override
+// schedules a new merge of the rates when it expires.
+func (s *Server) setOverride(req overrideRequest) (o rateOverride, err error) {
+	if !validCurrencyCode(req.Currency) {
+		return o, fmt.Errorf("Invalid currency: %s", req.Currency)
+	}
+
+	// every other rate is relative to EUR
+	if req.Currency == eur {
+		return o, fmt.Errorf("Rates are relative to %s, it cannot be overridden", eur)
+	}
+
+	if req.Rate <= 0 {
+		return o, fmt.Errorf("Invalid rate: %f", req.Rate)
+	}
+
+	o = rateOverride{Currency: req.Currency, Rate: req.Rate, Expires: req.Expires, Note: req.Note}
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			return o, fmt.Errorf("Invalid ttl: %s", req.TTL)
+		}
+
+		o.Expires = time.Now().Add(ttl)
+	}
+
+	if o.expired(time.Now()) {
+		return o, fmt.Errorf("Override already expired: %s", o.Expires)
+	}
+
+	s.rateMutex.Lock()
+	defer s.rateMutex.Unlock()
+
+	// rates given relative to another base are converted to EUR
+	if req.BaseCurrency != "" && req.BaseCurrency != eur {
+		baserate, found := s.currencies[req.BaseCurrency]
+		if !found {
+			return o, fmt.Errorf("Unknown currency: %s", req.BaseCurrency)
+		}
+
+		o.Rate = req.Rate * baserate
+	}
+
+	s.overrides[o.Currency] = o
+	s.mergeRates()
+
+	if !o.Expires.IsZero() {
+		time.AfterFunc(time.Until(o.Expires), func() {
+			s.rateMutex.Lock()
+			s.mergeRates()
+			s.rateMutex.Unlock()
+		})
+	}
+
+	s.logger.Info("Override set", "currency", o.Currency, "rate", o.Rate)
+	return o, nil
+}
+
+// clears the override for the given currency, all overrides if empty
+func (s *Server) clearOverride(currency string) {
+	s.rateMutex.Lock()
+	defer s.rateMutex.Unlock()
+
+	if currency == "" {
+		s.overrides = make(map[string]rateOverride)
+	} else {
+		delete(s.overrides, currency)
+	}
+
+	s.mergeRates()
+	s.logger.Info("Override cleared", "currency", currency)
+}
+
+// returns true if the code looks like a currency code, three or more upper
+// case letters to allow internal currencies like "POINTS". Used for the
+// overrides and the configured default base alike.
+func validCurrencyCode(code string) bool {
+	if len(code) < 3 {
+		return false
+	}
+
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+
+	return true
+}
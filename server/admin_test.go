@@ -0,0 +1,183 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAdminToken = "adminsecret"
+)
+
+var (
+	adminHeaders = map[string]string{"Authorization": "Bearer " + testAdminToken}
+)
+
+// returns the merged rate of the currency, the expiry timers change the
+// rates at any time
+func currentRate(name string) float64 {
+	server.rateMutex.RLock()
+	defer server.rateMutex.RUnlock()
+
+	return server.currencies[name]
+}
+
+func TestOverrideUnauthorized(t *testing.T) {
+	r := fireReq("/admin/overrides", http.MethodGet, nil)
+	expect(t, r, http.StatusUnauthorized, true, nil)
+
+	r = fireReqHeaders("/admin/overrides", http.MethodGet, nil, map[string]string{"Authorization": "Bearer wrong"})
+	expect(t, r, http.StatusUnauthorized, true, nil)
+}
+
+func TestOverrideCustomCurrency(t *testing.T) {
+	r := fireReqHeaders("/admin/overrides", http.MethodPost, overrideRequest{
+		Currency: "POINTS",
+		Rate:     100,
+		Note:     "loyalty points",
+	}, adminHeaders)
+	var o rateOverride
+	expect(t, r, http.StatusOK, true, &o)
+	if o.Currency != "POINTS" || o.Rate != 100 {
+		t.Fatal("Unexpected override:", o)
+	}
+
+	converted, err := server.convert("POINTS", eur, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if converted != 200 {
+		t.Fatal("Expected 200 points, got:", converted)
+	}
+
+	res, err := server.createResponse(eur)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rate := range res.Rates {
+		if rate.Name == "POINTS" && rate.Source != overrideSource {
+			t.Fatal("Expected override source, got:", rate.Source)
+		}
+
+		if rate.Name == "USD" && rate.Source != server.provider {
+			t.Fatal("Expected provider source, got:", rate.Source)
+		}
+	}
+
+	r = fireReqHeaders("/admin/overrides?currency=POINTS", http.MethodDelete, nil, adminHeaders)
+	expect(t, r, http.StatusOK, true, nil)
+	if _, err := server.convert("POINTS", eur, 2); err == nil {
+		t.Fatal("Shouldn't know currency after clearing: POINTS")
+	}
+}
+
+func TestOverrideRelativeToBase(t *testing.T) {
+	usd := currentRate("USD")
+	_, err := server.setOverride(overrideRequest{Currency: "DKK", Rate: 7, BaseCurrency: "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.clearOverride("DKK")
+
+	if currentRate("DKK") != 7*usd {
+		t.Fatal("Unexpected DKK rate:", currentRate("DKK"))
+	}
+
+	res, err := server.createConvertResponse("DKK", "USD", []float64{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Overrides) != 1 || res.Overrides[0] != "DKK" {
+		t.Fatal("Expected DKK to be marked as overridden:", res.Overrides)
+	}
+}
+
+func TestOverrideExpiry(t *testing.T) {
+	server.rateMutex.RLock()
+	feedRate := server.feed["SEK"]
+	server.rateMutex.RUnlock()
+
+	_, err := server.setOverride(overrideRequest{Currency: "SEK", Rate: 1, TTL: "50ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if currentRate("SEK") != 1 {
+		t.Fatal("Expected override to be active")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if rate := currentRate("SEK"); rate != feedRate {
+		t.Fatal("Expected override to expire, got:", rate)
+	}
+}
+
+func TestOverrideInvalid(t *testing.T) {
+	invalid := []overrideRequest{
+		{Currency: "x", Rate: 1},
+		{Currency: "AED", Rate: 0},
+		{Currency: "AED", Rate: 1, TTL: "soon"},
+		{Currency: "AED", Rate: 1, Expires: time.Now().Add(-time.Hour)},
+		{Currency: "AED", Rate: 1, BaseCurrency: "FOO"},
+		{Currency: eur, Rate: 2},
+	}
+
+	for _, req := range invalid {
+		r := fireReqHeaders("/admin/overrides", http.MethodPost, req, adminHeaders)
+		expect(t, r, http.StatusBadRequest, true, nil)
+	}
+}
+
+func TestOverrideWithoutCurrencies(t *testing.T) {
+	server.rateMutex.Lock()
+	server.hasCurrencies = false
+	server.rateMutex.Unlock()
+	defer func() {
+		server.rateMutex.Lock()
+		server.hasCurrencies = true
+		server.rateMutex.Unlock()
+	}()
+
+	r := fireReq("/currencies", http.MethodGet, nil)
+	expect(t, r, http.StatusServiceUnavailable, true, nil)
+
+	// the admin API works while the provider is down
+	r = fireReqHeaders("/admin/overrides", http.MethodPost, overrideRequest{Currency: "POINTS", Rate: 100}, adminHeaders)
+	expect(t, r, http.StatusOK, true, nil)
+	defer server.clearOverride("POINTS")
+
+	var list []rateOverride
+	r = fireReqHeaders("/admin/overrides", http.MethodGet, nil, adminHeaders)
+	expect(t, r, http.StatusOK, true, &list)
+	if len(list) != 1 || list[0].Currency != "POINTS" {
+		t.Fatal("Unexpected overrides:", list)
+	}
+}
+
+func TestOverrideConcurrency(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				fireReq("/currencies", http.MethodGet, nil)
+				fireReq("/convert", http.MethodPost, convertRequest{BaseCurrency: "USD", TargetCurrency: "POINTS", Amounts: []float64{1}})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				server.setOverride(overrideRequest{Currency: "POINTS", Rate: float64(j + 1), TTL: "1ms"})
+				server.clearOverride("POINTS")
+			}
+		}()
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,381 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// the scopes of the API keys
+const (
+	scopeRates    = "rates"    // read the rates, history and metadata
+	scopeConvert  = "convert"  // convert amounts
+	scopeWebhooks = "webhooks" // register webhooks
+
+	defaultKeyRate = "60" // default requests per minute of a key
+	apiKeyPrefix   = "gfs_"
+)
+
+// The scope required for every path, paths without a scope need no key.
+// The probes (/healthz, /readyz) are left out on purpose so load balancers
+// need no credentials, the monitoring (/metrics, /debug/vars) and the admin
+// endpoints are authorized with the admin token instead.
+var routeScopes = map[string]string{
+	"/currencies":  scopeRates,
+	"/script":      scopeRates,
+	"/script.d.ts": scopeRates,
+	"/aggregate":   scopeRates,
+	"/timeseries":  scopeRates,
+	"/chart.svg":   scopeRates,
+	"/catalogue":   scopeRates,
+	"/convert":     scopeConvert,
+	"/webhook":     scopeWebhooks,
+}
+
+// an API key, only the hash of the key is stored
+type apiKey struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash,omitempty"`
+	Scopes  []string  `json:"scopes"`
+	Rate    float64   `json:"rate"` // requests per minute
+	Burst   int       `json:"burst"`
+	Created time.Time `json:"created"`
+
+	bucket *tokenBucket
+}
+
+// struct for the key request, rate and burst default to the configured rate
+type keyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Rate   float64  `json:"rate"`
+	Burst  int      `json:"burst"`
+}
+
+// struct for the key response, the key is only returned when issued
+type keyResponse struct {
+	apiKey
+	Key string `json:"key"`
+}
+
+// A token bucket holding up to burst tokens, refilled with rate tokens per
+// second.
+type tokenBucket struct {
+	tokens float64
+	rate   float64
+	burst  float64
+	last   time.Time
+}
+
+func newTokenBucket(perMinute float64, burst int) *tokenBucket {
+	return &tokenBucket{tokens: float64(burst), rate: perMinute / 60, burst: float64(burst), last: time.Now()}
+}
+
+// Takes a token if available. Returns the remaining tokens, the time until
+// the bucket is full and the time until the next token if none was taken.
+func (b *tokenBucket) take(now time.Time) (ok bool, remaining int, reset, retry time.Duration) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+	} else {
+		retry = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+
+	reset = time.Duration((b.burst - b.tokens) / b.rate * float64(time.Second))
+	return ok, int(b.tokens), reset, retry
+}
+
+// returns true if the key has the scope
+func (k *apiKey) allows(scope string) bool {
+	return contains(k.Scopes, scope)
+}
+
+// returns the hex encoded hash of the key
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// returns n random bytes hex encoded
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	return hex.EncodeToString(b), err
+}
+
+// Loads the API keys from the JSON file at the given path. A missing file
+// is no error, it is created when the first key is issued.
+func loadApiKeys(path string) (keys map[string]*apiKey, err error) {
+	keys = make(map[string]*apiKey)
+	if path == "" {
+		return keys, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return keys, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error reading API keys: %s", err)
+	}
+
+	var list []*apiKey
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing API keys: %s", err)
+	}
+
+	// the rate limit divides by the rate, a key without one never refills
+	for _, k := range list {
+		if k.Rate <= 0 || k.Burst < 1 {
+			return nil, fmt.Errorf("Invalid rate limit of API key %s: %f/%d", k.ID, k.Rate, k.Burst)
+		}
+
+		k.bucket = newTokenBucket(k.Rate, k.Burst)
+		keys[k.Hash] = k
+	}
+
+	return keys, nil
+}
+
+// Saves the API keys to the keys file if configured. Must be called while
+// holding the key lock.
+func (s *Server) saveApiKeys() error {
+	if s.keysFile == "" {
+		return nil
+	}
+
+	list := []*apiKey{}
+	for _, k := range s.apiKeys {
+		list = append(list, k)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write and rename so a crash never leaves a partial file
+	tmp := s.keysFile + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.keysFile)
+}
+
+// returns the API key of the request, the header or the URL param where
+// headers can't be set
+func apiKeyOf(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+
+	return r.URL.Query().Get("api_key")
+}
+
+// Authorizes the request with its API key and applies the rate limit of the
+// key. Requests without a key are allowed if keys are not required. Returns
+// false if the request has been answered.
+func (s *Server) authorizeKey(w http.ResponseWriter, r *http.Request) bool {
+	scope, found := routeScopes[r.URL.Path]
+	if !found {
+		return true
+	}
+
+	key := apiKeyOf(r)
+	if key == "" {
+		if s.requireKeys {
+			http.Error(w, "API key required", http.StatusUnauthorized)
+			return false
+		}
+
+		return true
+	}
+
+	s.keyMutex.Lock()
+	k, found := s.apiKeys[hashKey(key)]
+	var ok bool
+	var remaining int
+	var reset, retry time.Duration
+	if found && k.allows(scope) {
+		ok, remaining, reset, retry = k.bucket.take(time.Now())
+	}
+	s.keyMutex.Unlock()
+
+	if !found {
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return false
+	}
+
+	if !k.allows(scope) {
+		http.Error(w, "API key lacks scope: "+scope, http.StatusForbidden)
+		return false
+	}
+
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(k.Burst))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return false
+	}
+
+	return true
+}
+
+// Handles the API keys (/admin/keys)
+func (s *Server) keysHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		// GET - list the keys without their hashes
+		s.keyMutex.Lock()
+		list := s.listApiKeys()
+		s.keyMutex.Unlock()
+		s.respondJson(w, list, nil)
+	} else if r.Method == http.MethodPost {
+		// POST - parse the request and issue a key
+		var req keyRequest
+		err := s.getJsonRequest(r, &req)
+		if err != nil {
+			requestLogger(r).Warn("Invalid request", "error", err)
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+
+		res, err := s.issueApiKey(req)
+		if err != nil {
+			requestLogger(r).Warn("API key rejected", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.respondJson(w, res, nil)
+	} else if r.Method == http.MethodDelete {
+		// DELETE - revoke a key
+		err := s.revokeApiKey(r.URL.Query().Get("id"))
+		if err != nil {
+			requestLogger(r).Warn("API key not revoked", "error", err)
+			http.Error(w, err.Error(), http.StatusNotFound)
+		}
+	} else {
+		http.Error(w, "", http.StatusBadRequest)
+	}
+}
+
+// returns the keys sorted by creation without their hashes, must be called
+// while holding the key lock
+func (s *Server) listApiKeys() (list []apiKey) {
+	list = []apiKey{}
+	for _, k := range s.apiKeys {
+		k := *k
+		k.Hash = ""
+		list = append(list, k)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Created.Equal(list[j].Created) {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list
+}
+
+// Validates the request and issues a new key. The key is only part of the
+// response, just its hash is kept.
+func (s *Server) issueApiKey(req keyRequest) (res keyResponse, err error) {
+	if len(req.Scopes) == 0 {
+		return res, fmt.Errorf("No scopes")
+	}
+
+	for _, scope := range req.Scopes {
+		if scope != scopeRates && scope != scopeConvert && scope != scopeWebhooks {
+			return res, fmt.Errorf("Unknown scope: %s", scope)
+		}
+	}
+
+	if req.Rate == 0 {
+		req.Rate = s.keyRate
+	}
+
+	if req.Burst == 0 {
+		req.Burst = int(math.Max(1, math.Ceil(req.Rate)))
+	}
+
+	if req.Rate < 0 || req.Burst < 1 {
+		return res, fmt.Errorf("Invalid rate limit: %f/%d", req.Rate, req.Burst)
+	}
+
+	id, err := randomHex(4)
+	if err != nil {
+		return res, err
+	}
+
+	secret, err := randomHex(24)
+	if err != nil {
+		return res, err
+	}
+
+	key := apiKeyPrefix + secret
+	k := &apiKey{
+		ID:      id,
+		Name:    req.Name,
+		Hash:    hashKey(key),
+		Scopes:  req.Scopes,
+		Rate:    req.Rate,
+		Burst:   req.Burst,
+		Created: time.Now().UTC(),
+		bucket:  newTokenBucket(req.Rate, req.Burst),
+	}
+
+	s.keyMutex.Lock()
+	defer s.keyMutex.Unlock()
+
+	s.apiKeys[k.Hash] = k
+	err = s.saveApiKeys()
+	if err != nil {
+		delete(s.apiKeys, k.Hash)
+		return res, fmt.Errorf("Error saving API keys: %s", err)
+	}
+
+	s.logger.Info("API key issued", "key_id", k.ID, "name", k.Name)
+	res = keyResponse{apiKey: *k, Key: key}
+	res.Hash = ""
+	return res, nil
+}
+
+// revokes the key with the given id
+func (s *Server) revokeApiKey(id string) error {
+	s.keyMutex.Lock()
+	defer s.keyMutex.Unlock()
+
+	for hash, k := range s.apiKeys {
+		if k.ID == id {
+			delete(s.apiKeys, hash)
+			s.logger.Info("API key revoked", "key_id", id)
+			return s.saveApiKeys()
+		}
+	}
+
+	return fmt.Errorf("Unknown API key: %s", id)
+}
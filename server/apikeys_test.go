@@ -0,0 +1,169 @@
+package server
+
+import (
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(60, 2)
+	now := b.last
+
+	for i := 1; i >= 0; i-- {
+		ok, remaining, _, _ := b.take(now)
+		if !ok || remaining != i {
+			t.Fatal("Expected a token:", remaining)
+		}
+	}
+
+	ok, _, reset, retry := b.take(now)
+	if ok || retry != time.Second || reset != 2*time.Second {
+		t.Fatal("Expected an empty bucket:", reset, retry)
+	}
+
+	if ok, _, _, _ := b.take(now.Add(time.Second)); !ok {
+		t.Fatal("Expected a refilled token")
+	}
+}
+
+func issueTestKey(t *testing.T, req keyRequest) keyResponse {
+	r := fireReqHeaders("/admin/keys", http.MethodPost, req, adminHeaders)
+	var res keyResponse
+	expect(t, r, http.StatusOK, true, &res)
+	if !strings.HasPrefix(res.Key, apiKeyPrefix) || res.Hash != "" {
+		t.Fatal("Unexpected key:", res)
+	}
+
+	return res
+}
+
+func TestApiKeyScopes(t *testing.T) {
+	key := issueTestKey(t, keyRequest{Name: "rates", Scopes: []string{scopeRates}})
+	headers := map[string]string{"X-API-Key": key.Key}
+
+	r := fireReqHeaders("/currencies", http.MethodGet, nil, headers)
+	expect(t, r, http.StatusOK, true, nil)
+	if r.Header().Get("X-RateLimit-Limit") != "60" || r.Header().Get("X-RateLimit-Remaining") != "59" {
+		t.Fatal("Unexpected rate limit headers:", r.Header())
+	}
+
+	r = fireReq("/currencies?api_key="+key.Key, http.MethodGet, nil)
+	expect(t, r, http.StatusOK, true, nil)
+
+	r = fireReqHeaders("/convert", http.MethodPost, convertRequest{BaseCurrency: eur, TargetCurrency: "USD"}, headers)
+	expect(t, r, http.StatusForbidden, true, nil)
+
+	r = fireReqHeaders("/currencies", http.MethodGet, nil, map[string]string{"X-API-Key": apiKeyPrefix + "wrong"})
+	expect(t, r, http.StatusUnauthorized, true, nil)
+}
+
+func TestApiKeyRequired(t *testing.T) {
+	defer func() { server.requireKeys = false }()
+	server.requireKeys = true
+
+	r := fireReq("/currencies", http.MethodGet, nil)
+	expect(t, r, http.StatusUnauthorized, true, nil)
+
+	// the admin API uses the admin token
+	r = fireReqHeaders("/admin/keys", http.MethodGet, nil, adminHeaders)
+	expect(t, r, http.StatusOK, true, nil)
+
+	// the probes take no key
+	r = fireReq("/healthz", http.MethodGet, nil)
+	expect(t, r, http.StatusOK, true, nil)
+}
+
+func TestApiKeyRateLimit(t *testing.T) {
+	key := issueTestKey(t, keyRequest{Scopes: []string{scopeConvert}, Rate: 1, Burst: 2})
+	headers := map[string]string{"X-API-Key": key.Key}
+	req := convertRequest{BaseCurrency: eur, TargetCurrency: "USD", Amounts: []float64{1}}
+
+	for i := 0; i < 2; i++ {
+		r := fireReqHeaders("/convert", http.MethodPost, req, headers)
+		expect(t, r, http.StatusOK, true, nil)
+	}
+
+	r := fireReqHeaders("/convert", http.MethodPost, req, headers)
+	expect(t, r, http.StatusTooManyRequests, true, nil)
+	if r.Header().Get("Retry-After") == "" || r.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatal("Unexpected rate limit headers:", r.Header())
+	}
+}
+
+func TestApiKeyRevoke(t *testing.T) {
+	key := issueTestKey(t, keyRequest{Name: "revoked", Scopes: []string{scopeRates}})
+
+	var list []apiKey
+	r := fireReqHeaders("/admin/keys", http.MethodGet, nil, adminHeaders)
+	expect(t, r, http.StatusOK, true, &list)
+	found := false
+	for _, k := range list {
+		found = found || k.ID == key.ID
+		if k.Hash != "" {
+			t.Fatal("Expected no hashes in the list")
+		}
+	}
+
+	if !found {
+		t.Fatal("Expected the key in the list")
+	}
+
+	r = fireReqHeaders("/admin/keys?id="+key.ID, http.MethodDelete, nil, adminHeaders)
+	expect(t, r, http.StatusOK, true, nil)
+
+	r = fireReqHeaders("/currencies", http.MethodGet, nil, map[string]string{"X-API-Key": key.Key})
+	expect(t, r, http.StatusUnauthorized, true, nil)
+
+	r = fireReqHeaders("/admin/keys?id="+key.ID, http.MethodDelete, nil, adminHeaders)
+	expect(t, r, http.StatusNotFound, true, nil)
+}
+
+func TestApiKeyInvalidRequest(t *testing.T) {
+	for _, req := range []keyRequest{{}, {Scopes: []string{"admin"}}, {Scopes: []string{scopeRates}, Rate: -1}} {
+		r := fireReqHeaders("/admin/keys", http.MethodPost, req, adminHeaders)
+		expect(t, r, http.StatusBadRequest, true, nil)
+	}
+}
+
+func TestApiKeysFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &Server{keysFile: filepath.Join(dir, "keys.json"), keyMutex: server.keyMutex, keyRate: 10, logger: slog.Default()}
+	s.apiKeys, err = loadApiKeys(s.keysFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := s.issueApiKey(keyRequest{Scopes: []string{scopeRates}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := ioutil.ReadFile(s.keysFile)
+	if strings.Contains(string(data), key.Key) {
+		t.Fatal("Expected only the hash to be saved")
+	}
+
+	keys, err := loadApiKeys(s.keysFile)
+	if err != nil || keys[hashKey(key.Key)] == nil || keys[hashKey(key.Key)].Burst != 10 {
+		t.Fatal("Expected the key to be loaded:", keys, err)
+	}
+
+	// keys without a rate are rejected
+	for _, limit := range []string{`"rate": 0, "burst": 10`, `"rate": -1, "burst": 10`, `"rate": 10, "burst": 0`} {
+		ioutil.WriteFile(s.keysFile, []byte(`[{"id": "1", "hash": "x", "scopes": ["rates"], `+limit+`}]`), 0600)
+		if _, err := loadApiKeys(s.keysFile); err == nil {
+			t.Fatal("Expected an error for the rate limit:", limit)
+		}
+	}
+}
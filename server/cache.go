@@ -0,0 +1,107 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	staleMaxAge = 5 * time.Minute // max age while new rates are expected but not fetched yet
+)
+
+// Returns the seconds until new rates are expected. When the next rates
+// should already have been published the short stale max age is used.
+// Must be called while holding the rate lock.
+func (s *Server) cacheMaxAge(now time.Time) int {
+	next := nextPublication(publicationTime(s.lastUpdateTime))
+	if !next.After(now) {
+		return int(staleMaxAge.Seconds())
+	}
+
+	return int(next.Sub(now).Seconds())
+}
+
+// Sets the caching headers for a response identified by the key within the
+// current rates. The last modification is when the rates last changed, the
+// overrides included. Responses are private when API keys are required or
+// the request has a key, so shared caches never serve them to other
+// clients, a key in the URL included. Returns true if the
+// client has the response already, in which case not modified has been
+// written. Conditions are only evaluated for GET and HEAD requests.
+func (s *Server) cacheResponse(w http.ResponseWriter, r *http.Request, key string) bool {
+	s.rateMutex.RLock()
+	etag := fmt.Sprintf(`"%s-%s"`, s.version, key)
+	lastModified := s.ratesChanged.UTC().Truncate(time.Second)
+	maxAge := s.cacheMaxAge(time.Now())
+	s.rateMutex.RUnlock()
+
+	visibility := "public"
+	if s.requireKeys {
+		visibility = "private"
+		w.Header().Add("Vary", "X-API-Key")
+	} else if apiKeyOf(r) != "" {
+		visibility = "private"
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", visibility, maxAge))
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	notModified := false
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		notModified = etagMatches(inm, etag)
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		notModified = err == nil && !lastModified.After(t)
+	}
+
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+	}
+
+	return notModified
+}
+
+// returns the cache key of a currency response
+func currencyKey(base string, details bool, locale string) string {
+	if !details {
+		return base
+	}
+
+	return base + "-" + localeLanguage(locale)
+}
+
+// returns true if the If-None-Match header matches the etag in any of the
+// content encodings, weak comparison as required for If-None-Match
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+
+		for _, encoding := range contentEncodings {
+			if candidate == encodedEtag(etag, encoding) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// returns a short hash of the request to use as a cache key
+func requestKey(v interface{}) string {
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
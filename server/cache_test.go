@@ -0,0 +1,129 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheHeaders(t *testing.T) {
+	r := fireReq("/currencies", http.MethodGet, nil)
+	expect(t, r, http.StatusOK, true, nil)
+
+	etag := r.Header().Get("ETag")
+	if etag != `"`+server.version+`-EUR.json"` {
+		t.Fatal("Unexpected ETag:", etag)
+	}
+
+	server.rateMutex.RLock()
+	lastModified := server.ratesChanged.UTC().Format(http.TimeFormat)
+	server.rateMutex.RUnlock()
+	if r.Header().Get("Last-Modified") != lastModified {
+		t.Fatal("Unexpected Last-Modified:", r.Header().Get("Last-Modified"))
+	}
+
+	maxAge, err := strconv.Atoi(strings.TrimPrefix(r.Header().Get("Cache-Control"), "public, max-age="))
+	if err != nil || maxAge <= 0 {
+		t.Fatal("Unexpected Cache-Control:", r.Header().Get("Cache-Control"))
+	}
+}
+
+func TestCacheNotModified(t *testing.T) {
+	etag := `"` + server.version + `-EUR.json"`
+	r := fireReqHeaders("/currencies", http.MethodGet, nil, map[string]string{"If-None-Match": `"other", ` + etag})
+	expect(t, r, http.StatusNotModified, true, nil)
+	if r.Body.Len() != 0 {
+		t.Fatal("Expected no body")
+	}
+
+	r = fireReqHeaders("/currencies", http.MethodGet, nil, map[string]string{"If-None-Match": `"other"`})
+	expect(t, r, http.StatusOK, true, nil)
+
+	since := time.Now().UTC().Format(http.TimeFormat)
+	r = fireReqHeaders("/currencies", http.MethodGet, nil, map[string]string{"If-Modified-Since": since})
+	expect(t, r, http.StatusNotModified, true, nil)
+
+	since = server.ratesChanged.Add(-time.Hour).UTC().Format(http.TimeFormat)
+	r = fireReqHeaders("/currencies", http.MethodGet, nil, map[string]string{"If-Modified-Since": since})
+	expect(t, r, http.StatusOK, true, nil)
+
+	r = fireReqHeaders("/script?base=USD", http.MethodGet, nil, map[string]string{"If-None-Match": "*"})
+	expect(t, r, http.StatusNotModified, true, nil)
+}
+
+func TestCacheConvert(t *testing.T) {
+	req := convertRequest{BaseCurrency: "DKK", TargetCurrency: "USD", Amounts: []float64{1}}
+	r := fireReqHeaders("/convert", http.MethodPost, req, map[string]string{"If-None-Match": "*"})
+	expect(t, r, http.StatusOK, true, nil)
+
+	etag := r.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected an ETag")
+	}
+
+	req.Amounts = []float64{2}
+	r = fireReq("/convert", http.MethodPost, req)
+	if r.Header().Get("ETag") == etag {
+		t.Fatal("Expected another ETag for another request")
+	}
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	s := &Server{}
+	s.lastUpdateTime, _ = time.Parse(currencyDateFormat, "2026-10-16")
+
+	now, _ := time.Parse(time.RFC3339, "2026-10-19T15:00:00+02:00")
+	if s.cacheMaxAge(now) != 3600 {
+		t.Fatal("Expected an hour until the next rates:", s.cacheMaxAge(now))
+	}
+
+	now, _ = time.Parse(time.RFC3339, "2026-10-19T17:00:00+02:00")
+	if s.cacheMaxAge(now) != int(staleMaxAge.Seconds()) {
+		t.Fatal("Expected the stale max age:", s.cacheMaxAge(now))
+	}
+}
+
+func TestCacheOverrideModified(t *testing.T) {
+	server.rateMutex.Lock()
+	server.ratesChanged = time.Now().Add(-time.Hour)
+	server.rateMutex.Unlock()
+
+	r := fireReq("/currencies", http.MethodGet, nil)
+	since := r.Header().Get("Last-Modified")
+	r = fireReqHeaders("/currencies", http.MethodGet, nil, map[string]string{"If-Modified-Since": since})
+	expect(t, r, http.StatusNotModified, true, nil)
+
+	// an override changes the rates without a new fetch
+	if _, err := server.setOverride(overrideRequest{Currency: "POINTS", Rate: 100}); err != nil {
+		t.Fatal(err)
+	}
+	defer server.clearOverride("POINTS")
+
+	r = fireReqHeaders("/currencies", http.MethodGet, nil, map[string]string{"If-Modified-Since": since})
+	expect(t, r, http.StatusOK, true, nil)
+	if r.Header().Get("Last-Modified") == since {
+		t.Fatal("Expected a new Last-Modified after the override")
+	}
+}
+
+func TestCachePrivateWithKeys(t *testing.T) {
+	defer func() { server.requireKeys = false }()
+	server.requireKeys = true
+
+	key := issueTestKey(t, keyRequest{Name: "cache", Scopes: []string{scopeRates}})
+	r := fireReqHeaders("/currencies", http.MethodGet, nil, map[string]string{"X-API-Key": key.Key})
+	expect(t, r, http.StatusOK, true, nil)
+	if !strings.HasPrefix(r.Header().Get("Cache-Control"), "private, ") || !strings.Contains(strings.Join(r.Header()["Vary"], ","), "X-API-Key") {
+		t.Fatal("Expected a private response:", r.Header())
+	}
+
+	// a key in the URL is private even if keys are optional
+	server.requireKeys = false
+	r = fireReq("/currencies?api_key="+key.Key, http.MethodGet, nil)
+	expect(t, r, http.StatusOK, true, nil)
+	if !strings.HasPrefix(r.Header().Get("Cache-Control"), "private, ") {
+		t.Fatal("Expected a private response:", r.Header())
+	}
+}
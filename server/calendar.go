@@ -0,0 +1,81 @@
+package server
+
+import (
+	"time"
+)
+
+const (
+	publicationHour = 16 // the ECB publishes the rates around 16:00 CET
+)
+
+// the time zone of the ECB, a fixed CET offset if the zone data is missing
+var ecbLocation = loadLocation("Europe/Berlin", 1*60*60)
+
+// loads the named location, falls back to a fixed offset (in seconds)
+func loadLocation(name string, offset int) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.FixedZone(name, offset)
+	}
+
+	return loc
+}
+
+// Returns the date of easter sunday in the given year (the anonymous
+// gregorian algorithm).
+func easter(year int) time.Time {
+	a := year % 19
+	b, c := year/100, year%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
+
+// Returns true if the ECB publishes rates on the given date. No rates are
+// published on weekends and TARGET holidays: new year, good friday, easter
+// monday, labour day and the two christmas days.
+func isPublicationDay(date time.Time) bool {
+	if date.Weekday() == time.Saturday || date.Weekday() == time.Sunday {
+		return false
+	}
+
+	month, day := date.Month(), date.Day()
+	switch {
+	case month == time.January && day == 1,
+		month == time.May && day == 1,
+		month == time.December && (day == 25 || day == 26):
+		return false
+	}
+
+	e := easter(date.Year())
+	d := time.Date(date.Year(), month, day, 0, 0, 0, 0, time.UTC)
+	if d.Equal(e.AddDate(0, 0, -2)) || d.Equal(e.AddDate(0, 0, 1)) {
+		return false
+	}
+
+	return true
+}
+
+// Returns the first expected publication of new rates after the given time.
+func nextPublication(after time.Time) time.Time {
+	t := after.In(ecbLocation)
+	day := time.Date(t.Year(), t.Month(), t.Day(), publicationHour, 0, 0, 0, ecbLocation)
+	for !day.After(after) || !isPublicationDay(day) {
+		day = time.Date(day.Year(), day.Month(), day.Day()+1, publicationHour, 0, 0, 0, ecbLocation)
+	}
+
+	return day
+}
+
+// Returns the publication time of the rates of the given date.
+func publicationTime(date time.Time) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), publicationHour, 0, 0, 0, ecbLocation)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEaster(t *testing.T) {
+	dates := map[int]string{2016: "2016-03-27", 2019: "2019-04-21", 2024: "2024-03-31", 2026: "2026-04-05"}
+	for year, date := range dates {
+		if easter(year).Format(currencyDateFormat) != date {
+			t.Fatal("Unexpected easter:", year, easter(year))
+		}
+	}
+}
+
+func TestPublicationDay(t *testing.T) {
+	days := map[string]bool{
+		"2026-10-16": true,  // friday
+		"2026-10-17": false, // saturday
+		"2026-01-01": false, // new year
+		"2026-04-03": false, // good friday
+		"2026-04-06": false, // easter monday
+		"2026-04-07": true,
+		"2026-05-01": false, // labour day
+		"2026-12-24": true,
+		"2026-12-25": false,
+		"2026-12-28": true,
+	}
+
+	for date, expected := range days {
+		d, _ := time.Parse(currencyDateFormat, date)
+		if isPublicationDay(d) != expected {
+			t.Fatal("Unexpected publication day:", date)
+		}
+	}
+}
+
+func TestNextPublication(t *testing.T) {
+	cases := map[string]string{
+		"2026-10-16T10:00:00+02:00": "2026-10-16T16:00:00+02:00", // friday morning
+		"2026-10-16T16:00:00+02:00": "2026-10-19T16:00:00+02:00", // friday at publication
+		"2026-10-17T12:00:00+02:00": "2026-10-19T16:00:00+02:00", // saturday
+		"2026-04-02T17:00:00+02:00": "2026-04-07T16:00:00+02:00", // before easter
+		"2026-12-24T18:00:00+01:00": "2026-12-28T16:00:00+01:00", // christmas
+	}
+
+	for now, expected := range cases {
+		t1, _ := time.Parse(time.RFC3339, now)
+		t2, _ := time.Parse(time.RFC3339, expected)
+		if next := nextPublication(t1); !next.Equal(t2) {
+			t.Fatal("Unexpected next publication:", now, next)
+		}
+	}
+}
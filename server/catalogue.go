@@ -0,0 +1,208 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// the metadata of a currency, names are the CLDR names in english with
+// translations for the supported locales
+type currencyInfo struct {
+	Code       string   `json:"code" xml:"code,attr"`
+	Name       string   `json:"name" xml:"name,attr"`
+	Symbol     string   `json:"symbol" xml:"symbol,attr"`
+	Numeric    string   `json:"numeric" xml:"numeric,attr"`
+	MinorUnits int      `json:"minor_units" xml:"minor_units,attr"`
+	Countries  []string `json:"countries" xml:"Country"`
+	Published  bool     `json:"published" xml:"published,attr"`
+
+	names map[string]string // localized names by language
+}
+
+// struct for the currency catalogue
+type catalogueResponse struct {
+	Locale     string         `json:"locale"`
+	Currencies []currencyInfo `json:"currencies"`
+}
+
+// the known currencies, every currency published by the ECB along with
+// currencies commonly added as overrides
+var catalogue = map[string]currencyInfo{
+	"EUR": {Code: "EUR", Name: "Euro", Symbol: "€", Numeric: "978", MinorUnits: 2,
+		Countries: []string{"AT", "BE", "CY", "DE", "EE", "ES", "FI", "FR", "GR", "HR", "IE", "IT", "LT", "LU", "LV", "MT", "NL", "PT", "SI", "SK"},
+		names:     map[string]string{"da": "euro", "de": "Euro"}},
+	"USD": {Code: "USD", Name: "US Dollar", Symbol: "$", Numeric: "840", MinorUnits: 2,
+		Countries: []string{"US", "EC", "SV", "TL", "PW", "MH", "FM"},
+		names:     map[string]string{"da": "amerikansk dollar", "de": "US-Dollar"}},
+	"JPY": {Code: "JPY", Name: "Japanese Yen", Symbol: "¥", Numeric: "392", MinorUnits: 0,
+		Countries: []string{"JP"},
+		names:     map[string]string{"da": "japansk yen", "de": "Japanischer Yen"}},
+	"BGN": {Code: "BGN", Name: "Bulgarian Lev", Symbol: "лв.", Numeric: "975", MinorUnits: 2,
+		Countries: []string{"BG"},
+		names:     map[string]string{"da": "bulgarsk lev", "de": "Bulgarischer Lew"}},
+	"CZK": {Code: "CZK", Name: "Czech Koruna", Symbol: "Kč", Numeric: "203", MinorUnits: 2,
+		Countries: []string{"CZ"},
+		names:     map[string]string{"da": "tjekkisk koruna", "de": "Tschechische Krone"}},
+	"DKK": {Code: "DKK", Name: "Danish Krone", Symbol: "kr.", Numeric: "208", MinorUnits: 2,
+		Countries: []string{"DK", "FO", "GL"},
+		names:     map[string]string{"da": "dansk krone", "de": "Dänische Krone"}},
+	"GBP": {Code: "GBP", Name: "British Pound", Symbol: "£", Numeric: "826", MinorUnits: 2,
+		Countries: []string{"GB", "IM", "JE", "GG"},
+		names:     map[string]string{"da": "britisk pund", "de": "Britisches Pfund"}},
+	"HUF": {Code: "HUF", Name: "Hungarian Forint", Symbol: "Ft", Numeric: "348", MinorUnits: 2,
+		Countries: []string{"HU"},
+		names:     map[string]string{"da": "ungarsk forint", "de": "Ungarischer Forint"}},
+	"PLN": {Code: "PLN", Name: "Polish Zloty", Symbol: "zł", Numeric: "985", MinorUnits: 2,
+		Countries: []string{"PL"},
+		names:     map[string]string{"da": "polsk zloty", "de": "Polnischer Złoty"}},
+	"RON": {Code: "RON", Name: "Romanian Leu", Symbol: "lei", Numeric: "946", MinorUnits: 2,
+		Countries: []string{"RO"},
+		names:     map[string]string{"da": "rumænsk leu", "de": "Rumänischer Leu"}},
+	"SEK": {Code: "SEK", Name: "Swedish Krona", Symbol: "kr", Numeric: "752", MinorUnits: 2,
+		Countries: []string{"SE"},
+		names:     map[string]string{"da": "svensk krone", "de": "Schwedische Krone"}},
+	"CHF": {Code: "CHF", Name: "Swiss Franc", Symbol: "CHF", Numeric: "756", MinorUnits: 2,
+		Countries: []string{"CH", "LI"},
+		names:     map[string]string{"da": "schweizisk franc", "de": "Schweizer Franken"}},
+	"ISK": {Code: "ISK", Name: "Icelandic Króna", Symbol: "kr", Numeric: "352", MinorUnits: 0,
+		Countries: []string{"IS"},
+		names:     map[string]string{"da": "islandsk krone", "de": "Isländische Krone"}},
+	"NOK": {Code: "NOK", Name: "Norwegian Krone", Symbol: "kr", Numeric: "578", MinorUnits: 2,
+		Countries: []string{"NO", "SJ", "BV"},
+		names:     map[string]string{"da": "norsk krone", "de": "Norwegische Krone"}},
+	"TRY": {Code: "TRY", Name: "Turkish Lira", Symbol: "₺", Numeric: "949", MinorUnits: 2,
+		Countries: []string{"TR"},
+		names:     map[string]string{"da": "tyrkisk lira", "de": "Türkische Lira"}},
+	"AUD": {Code: "AUD", Name: "Australian Dollar", Symbol: "A$", Numeric: "036", MinorUnits: 2,
+		Countries: []string{"AU", "CC", "CX", "HM", "KI", "NF", "NR", "TV"},
+		names:     map[string]string{"da": "australsk dollar", "de": "Australischer Dollar"}},
+	"BRL": {Code: "BRL", Name: "Brazilian Real", Symbol: "R$", Numeric: "986", MinorUnits: 2,
+		Countries: []string{"BR"},
+		names:     map[string]string{"da": "brasiliansk real", "de": "Brasilianischer Real"}},
+	"CAD": {Code: "CAD", Name: "Canadian Dollar", Symbol: "CA$", Numeric: "124", MinorUnits: 2,
+		Countries: []string{"CA"},
+		names:     map[string]string{"da": "canadisk dollar", "de": "Kanadischer Dollar"}},
+	"CNY": {Code: "CNY", Name: "Chinese Yuan", Symbol: "CN¥", Numeric: "156", MinorUnits: 2,
+		Countries: []string{"CN"},
+		names:     map[string]string{"da": "kinesisk yuan", "de": "Renminbi Yuan"}},
+	"HKD": {Code: "HKD", Name: "Hong Kong Dollar", Symbol: "HK$", Numeric: "344", MinorUnits: 2,
+		Countries: []string{"HK"},
+		names:     map[string]string{"da": "hongkongsk dollar", "de": "Hongkong-Dollar"}},
+	"IDR": {Code: "IDR", Name: "Indonesian Rupiah", Symbol: "Rp", Numeric: "360", MinorUnits: 2,
+		Countries: []string{"ID"},
+		names:     map[string]string{"da": "indonesisk rupiah", "de": "Indonesische Rupiah"}},
+	"ILS": {Code: "ILS", Name: "Israeli New Shekel", Symbol: "₪", Numeric: "376", MinorUnits: 2,
+		Countries: []string{"IL", "PS"},
+		names:     map[string]string{"da": "israelsk ny shekel", "de": "Israelischer Neuer Schekel"}},
+	"INR": {Code: "INR", Name: "Indian Rupee", Symbol: "₹", Numeric: "356", MinorUnits: 2,
+		Countries: []string{"IN", "BT"},
+		names:     map[string]string{"da": "indisk rupee", "de": "Indische Rupie"}},
+	"KRW": {Code: "KRW", Name: "South Korean Won", Symbol: "₩", Numeric: "410", MinorUnits: 0,
+		Countries: []string{"KR"},
+		names:     map[string]string{"da": "sydkoreansk won", "de": "Südkoreanischer Won"}},
+	"MXN": {Code: "MXN", Name: "Mexican Peso", Symbol: "MX$", Numeric: "484", MinorUnits: 2,
+		Countries: []string{"MX"},
+		names:     map[string]string{"da": "mexicansk peso", "de": "Mexikanischer Peso"}},
+	"MYR": {Code: "MYR", Name: "Malaysian Ringgit", Symbol: "RM", Numeric: "458", MinorUnits: 2,
+		Countries: []string{"MY"},
+		names:     map[string]string{"da": "malaysisk ringgit", "de": "Malaysischer Ringgit"}},
+	"NZD": {Code: "NZD", Name: "New Zealand Dollar", Symbol: "NZ$", Numeric: "554", MinorUnits: 2,
+		Countries: []string{"NZ", "CK", "NU", "PN", "TK"},
+		names:     map[string]string{"da": "newzealandsk dollar", "de": "Neuseeland-Dollar"}},
+	"PHP": {Code: "PHP", Name: "Philippine Peso", Symbol: "₱", Numeric: "608", MinorUnits: 2,
+		Countries: []string{"PH"},
+		names:     map[string]string{"da": "filippinsk peso", "de": "Philippinischer Peso"}},
+	"SGD": {Code: "SGD", Name: "Singapore Dollar", Symbol: "S$", Numeric: "702", MinorUnits: 2,
+		Countries: []string{"SG"},
+		names:     map[string]string{"da": "singaporeansk dollar", "de": "Singapur-Dollar"}},
+	"THB": {Code: "THB", Name: "Thai Baht", Symbol: "฿", Numeric: "764", MinorUnits: 2,
+		Countries: []string{"TH"},
+		names:     map[string]string{"da": "thailandsk baht", "de": "Thailändischer Baht"}},
+	"ZAR": {Code: "ZAR", Name: "South African Rand", Symbol: "R", Numeric: "710", MinorUnits: 2,
+		Countries: []string{"ZA", "LS", "NA"},
+		names:     map[string]string{"da": "sydafrikansk rand", "de": "Südafrikanischer Rand"}},
+	"AED": {Code: "AED", Name: "UAE Dirham", Symbol: "AED", Numeric: "784", MinorUnits: 2,
+		Countries: []string{"AE"},
+		names:     map[string]string{"da": "emiratisk dirham", "de": "VAE-Dirham"}},
+	"VND": {Code: "VND", Name: "Vietnamese Dong", Symbol: "₫", Numeric: "704", MinorUnits: 0,
+		Countries: []string{"VN"},
+		names:     map[string]string{"da": "vietnamesisk dong", "de": "Vietnamesischer Dong"}},
+}
+
+// the languages of the names in the catalogue
+var catalogueLanguages = map[string]bool{"en": true, "da": true, "de": true}
+
+// Returns the lower case locale and its language, "da_DK" is "da-dk" and
+// "da".
+func splitLocale(locale string) (full, lang string) {
+	full = strings.ToLower(strings.Replace(locale, "_", "-", -1))
+	lang = full
+	if i := strings.Index(full, "-"); i >= 0 {
+		lang = full[:i]
+	}
+
+	return full, lang
+}
+
+// Returns the language of a locale ("da-DK" and "da_DK" are "da"). Unknown
+// languages fall back to english.
+func localeLanguage(locale string) string {
+	_, lang := splitLocale(locale)
+	if !catalogueLanguages[lang] {
+		return "en"
+	}
+
+	return lang
+}
+
+// Returns the metadata of the currency with the name in the language of the
+// locale and whether the provider currently publishes it.
+func (s *Server) currencyInfo(code, locale string) (info currencyInfo, found bool) {
+	info, found = catalogue[code]
+	if !found {
+		return info, false
+	}
+
+	if name, found := info.names[localeLanguage(locale)]; found {
+		info.Name = name
+	}
+
+	s.rateMutex.RLock()
+	_, info.Published = s.feed[code]
+	s.rateMutex.RUnlock()
+
+	return info, true
+}
+
+// Adds the currency metadata to the rates of a currency response. Rates of
+// currencies not in the catalogue are left as they are.
+func (s *Server) addCurrencyDetails(response *currencyResponse, locale string) {
+	for i, rate := range response.Rates {
+		if info, found := s.currencyInfo(rate.Name, locale); found {
+			response.Rates[i].Currency = &info
+		}
+	}
+}
+
+// Handles the currency catalogue requests (/catalogue)
+func (s *Server) catalogueHandler(w http.ResponseWriter, r *http.Request) {
+	// only handle GET, error on everything else
+	if r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	locale := r.URL.Query().Get("locale")
+	response := catalogueResponse{Locale: localeLanguage(locale)}
+	for code := range catalogue {
+		info, _ := s.currencyInfo(code, locale)
+		response.Currencies = append(response.Currencies, info)
+	}
+
+	sort.Slice(response.Currencies, func(i, j int) bool {
+		return response.Currencies[i].Code < response.Currencies[j].Code
+	})
+
+	s.respondJson(w, response, nil)
+}
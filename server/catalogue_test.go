@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestLocaleLanguage(t *testing.T) {
+	locales := map[string]string{"da-DK": "da", "de_AT": "de", "DA": "da", "en-US": "en", "fr": "en", "": "en"}
+	for locale, lang := range locales {
+		if localeLanguage(locale) != lang {
+			t.Fatal("Unexpected language:", locale, localeLanguage(locale))
+		}
+	}
+}
+
+func TestCatalogue(t *testing.T) {
+	var res catalogueResponse
+	r := fireReq("/catalogue?locale=da-DK", http.MethodGet, nil)
+	expect(t, r, http.StatusOK, true, &res)
+	if res.Locale != "da" || len(res.Currencies) != len(catalogue) {
+		t.Fatal("Unexpected catalogue:", res.Locale, len(res.Currencies))
+	}
+
+	for i, info := range res.Currencies {
+		if i > 0 && res.Currencies[i-1].Code >= info.Code {
+			t.Fatal("Expected the catalogue to be sorted")
+		}
+
+		if info.Code == "DKK" && (info.Name != "dansk krone" || info.Symbol != "kr." || info.MinorUnits != 2 || !info.Published) {
+			t.Fatal("Unexpected DKK:", info)
+		}
+
+		if info.Code == "VND" && info.Published {
+			t.Fatal("VND shouldn't be published")
+		}
+	}
+}
+
+func TestCatalogueComplete(t *testing.T) {
+	for code, info := range catalogue {
+		if info.Code != code || info.Name == "" || info.Symbol == "" || len(info.Numeric) != 3 || len(info.Countries) == 0 {
+			t.Fatal("Incomplete catalogue entry:", code)
+		}
+
+		for lang := range catalogueLanguages {
+			if _, found := info.names[lang]; !found && lang != "en" {
+				t.Fatal("Missing name:", code, lang)
+			}
+		}
+	}
+}
+
+func TestCurrencyDetails(t *testing.T) {
+	var res currencyResponse
+	r := fireReq("/currencies?details=true&locale=de", http.MethodGet, nil)
+	expect(t, r, http.StatusOK, true, &res)
+	for _, rate := range res.Rates {
+		if rate.Name == "USD" && (rate.Currency == nil || rate.Currency.Name != "US-Dollar") {
+			t.Fatal("Unexpected details:", rate.Currency)
+		}
+	}
+
+	res = currencyResponse{}
+	r = fireReq("/currencies", http.MethodPost, &currencyRequest{BaseCurrency: "USD"})
+	expect(t, r, http.StatusOK, true, &res)
+	if res.Rates[0].Currency != nil {
+		t.Fatal("Expected no details unless requested")
+	}
+}
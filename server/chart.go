@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultChartWidth  = 600
+	defaultChartHeight = 300
+	minChartSize       = 100
+	maxChartSize       = 2000
+
+	chartPadding = 50 // space around the plot for labels
+	chartTicks   = 5  // number of horizontal grid lines
+)
+
+// the colors of a chart
+type chartTheme struct {
+	background string
+	grid       string
+	text       string
+	line       string
+	average    string
+}
+
+// the known chart themes
+var chartThemes = map[string]chartTheme{
+	"light": {background: "#ffffff", grid: "#e5e5e5", text: "#333333", line: "#1f77b4", average: "#ff7f0e"},
+	"dark":  {background: "#1e1e1e", grid: "#3c3c3c", text: "#d4d4d4", line: "#4fc1ff", average: "#ffb86c"},
+}
+
+// the options for rendering a chart
+type chartOptions struct {
+	title   string
+	width   int
+	height  int
+	average int // days in the moving average, 0 disables it
+	theme   chartTheme
+}
+
+// a single point in a chart
+type chartPoint struct {
+	date time.Time
+	rate float64
+}
+
+// Returns the simple moving average over the given number of points. The
+// first points average over the points available so far.
+func movingAverage(points []chartPoint, n int) (averages []chartPoint) {
+	sum := 0.0
+	for i, p := range points {
+		sum += p.rate
+		if i >= n {
+			sum -= points[i-n].rate
+		}
+
+		count := i + 1
+		if count > n {
+			count = n
+		}
+
+		averages = append(averages, chartPoint{date: p.date, rate: sum / float64(count)})
+	}
+
+	return averages
+}
+
+// Renders the points as an SVG line chart. The rates are scaled to fill the
+// plot, the dates are spaced evenly as there are no rates on weekends.
+func renderChart(points []chartPoint, opts chartOptions) []byte {
+	min, max := points[0].rate, points[0].rate
+	for _, p := range points {
+		if p.rate < min {
+			min = p.rate
+		}
+
+		if p.rate > max {
+			max = p.rate
+		}
+	}
+
+	// a flat line is drawn in the middle
+	if max == min {
+		min, max = min*0.99, max*1.01
+	}
+
+	plotWidth := float64(opts.width - 2*chartPadding)
+	plotHeight := float64(opts.height - 2*chartPadding)
+	x := func(i int) float64 {
+		if len(points) == 1 {
+			return chartPadding + plotWidth/2
+		}
+
+		return chartPadding + plotWidth*float64(i)/float64(len(points)-1)
+	}
+	y := func(rate float64) float64 {
+		return chartPadding + plotHeight*(max-rate)/(max-min)
+	}
+	polyline := func(points []chartPoint) string {
+		coords := make([]string, len(points))
+		for i, p := range points {
+			coords[i] = fmt.Sprintf("%.1f,%.1f", x(i), y(p.rate))
+		}
+
+		return strings.Join(coords, " ")
+	}
+
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="12">`+"\n", opts.width, opts.height, opts.width, opts.height)
+	fmt.Fprintf(b, `<rect width="100%%" height="100%%" fill="%s"/>`+"\n", opts.theme.background)
+	fmt.Fprintf(b, `<text x="%d" y="%d" fill="%s" font-size="14">%s</text>`+"\n", chartPadding, chartPadding/2, opts.theme.text, html.EscapeString(opts.title))
+
+	// the grid with the rate labels
+	for i := 0; i < chartTicks; i++ {
+		rate := min + (max-min)*float64(i)/float64(chartTicks-1)
+		fmt.Fprintf(b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="%s"/>`+"\n", chartPadding, y(rate), opts.width-chartPadding, y(rate), opts.theme.grid)
+		fmt.Fprintf(b, `<text x="%d" y="%.1f" fill="%s" text-anchor="end" dominant-baseline="middle">%s</text>`+"\n", chartPadding-5, y(rate), opts.theme.text, strconv.FormatFloat(rate, 'f', 4, 64))
+	}
+
+	// the first and the last date
+	first, last := points[0].date.Format(currencyDateFormat), points[len(points)-1].date.Format(currencyDateFormat)
+	fmt.Fprintf(b, `<text x="%d" y="%d" fill="%s">%s</text>`+"\n", chartPadding, opts.height-chartPadding/2, opts.theme.text, first)
+	fmt.Fprintf(b, `<text x="%d" y="%d" fill="%s" text-anchor="end">%s</text>`+"\n", opts.width-chartPadding, opts.height-chartPadding/2, opts.theme.text, last)
+
+	fmt.Fprintf(b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`+"\n", polyline(points), opts.theme.line)
+	if opts.average > 1 {
+		fmt.Fprintf(b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="1.5" stroke-dasharray="4 3"/>`+"\n", polyline(movingAverage(points, opts.average)), opts.theme.average)
+	}
+
+	b.WriteString("</svg>\n")
+	return b.Bytes()
+}
+
+// parses an integer query parameter within min and max, def if empty
+func parseIntParam(value string, def, min, max int) (i int, err error) {
+	if value == "" {
+		return def, nil
+	}
+
+	i, err = strconv.Atoi(value)
+	if err != nil || i < min || i > max {
+		return 0, fmt.Errorf("Invalid value: %s", value)
+	}
+
+	return i, nil
+}
+
+// Handles the chart requests (/chart.svg)
+func (s *Server) chartHandler(w http.ResponseWriter, r *http.Request) {
+	// only handle GET, error on everything else
+	if r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	from, to := q.Get("from"), q.Get("to")
+	opts := chartOptions{title: from + "/" + to}
+
+	var err error
+	opts.width, err = parseIntParam(q.Get("width"), defaultChartWidth, minChartSize, maxChartSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts.height, err = parseIntParam(q.Get("height"), defaultChartHeight, minChartSize, maxChartSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts.average, err = parseIntParam(q.Get("ma"), 0, 0, 365)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	themeName := q.Get("theme")
+	if themeName == "" {
+		themeName = "light"
+	}
+
+	theme, found := chartThemes[themeName]
+	if !found {
+		http.Error(w, "Unknown theme: "+themeName, http.StatusBadRequest)
+		return
+	}
+	opts.theme = theme
+
+	start, end, err := parseRange(q.Get("start"), q.Get("end"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	buckets, err := s.seriesBuckets(to, from, start, end, intervalDaily)
+	if err != nil {
+		http.Error(w, "Error creating chart", http.StatusInternalServerError)
+		return
+	}
+
+	// the daily closing rates
+	points := make([]chartPoint, len(buckets))
+	for i, bucket := range buckets {
+		points[i] = chartPoint{date: bucket.start, rate: bucket.aggregate.Close}
+	}
+
+	w.Header().Add("Content-Type", "image/svg+xml")
+	w.Write(renderChart(points, opts))
+}
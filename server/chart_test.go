@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMovingAverage(t *testing.T) {
+	points := []chartPoint{{rate: 1}, {rate: 3}, {rate: 5}, {rate: 7}}
+	averages := movingAverage(points, 2)
+	expected := []float64{1, 2, 4, 6}
+	for i, a := range averages {
+		if a.rate != expected[i] {
+			t.Fatal("Unexpected average at index:", i, a.rate)
+		}
+	}
+}
+
+func TestChart(t *testing.T) {
+	addTestHistory()
+	r := fireReq("/chart.svg?from=USD&to=DKK&start=2001-01-01&end=2001-02-28&width=400&height=200&ma=2&theme=dark", http.MethodGet, nil)
+	expect(t, r, http.StatusOK, true, nil)
+	if r.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Fatal("Unexpected content type:", r.Header().Get("Content-Type"))
+	}
+
+	body := r.Body.String()
+	if strings.Count(body, "<polyline") != 2 {
+		t.Fatal("Expected the rate and the average lines")
+	}
+
+	if !strings.Contains(body, chartThemes["dark"].background) {
+		t.Fatal("Expected the dark theme")
+	}
+
+	// the chart must be well formed
+	d := xml.NewDecoder(strings.NewReader(body))
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestChartEscapesTitle(t *testing.T) {
+	points := []chartPoint{{rate: 1}, {rate: 2}}
+	svg := string(renderChart(points, chartOptions{title: "<script>", width: 200, height: 200, theme: chartThemes["light"]}))
+	if strings.Contains(svg, "<script>") {
+		t.Fatal("Expected the title to be escaped")
+	}
+}
+
+func TestChartInvalid(t *testing.T) {
+	invalid := []string{
+		"/chart.svg?from=USD&to=DKK&width=10",
+		"/chart.svg?from=USD&to=DKK&height=wide",
+		"/chart.svg?from=USD&to=DKK&ma=-1",
+		"/chart.svg?from=USD&to=DKK&theme=pink",
+		"/chart.svg?from=USD&to=DKK&start=yesterday",
+	}
+
+	for _, endpoint := range invalid {
+		r := fireReq(endpoint, http.MethodGet, nil)
+		expect(t, r, http.StatusBadRequest, true, nil)
+	}
+
+	r := fireReq("/chart.svg?from=USD&to=FOO", http.MethodGet, nil)
+	expect(t, r, http.StatusInternalServerError, true, nil)
+}
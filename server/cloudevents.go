@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// the CloudEvents modes of the push channels
+const (
+	cloudEventsBinary     = "binary"     // the data as body, the attributes as ce- headers
+	cloudEventsStructured = "structured" // the attributes and the data as a JSON envelope
+
+	cloudEventsVersion     = "1.0"
+	cloudEventsContentType = "application/cloudevents+json; charset=utf-8"
+
+	ratesUpdatedEvent    = "com.github.goingfullstack.currencyconverter.rates.updated"
+	webhookDisabledEvent = "com.github.goingfullstack.currencyconverter.webhook.disabled"
+)
+
+// a CloudEvents 1.0 event, the data is set when encoding
+type cloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	Type            string      `json:"type"`
+	Source          string      `json:"source"`
+	ID              string      `json:"id"`
+	Time            time.Time   `json:"time"`
+	Subject         string      `json:"subject,omitempty"`
+	DataContentType string      `json:"datacontenttype,omitempty"`
+	Data            interface{} `json:"data,omitempty"`
+}
+
+// returns an error if the mode is not a CloudEvents mode, empty disables
+func checkCloudEventsMode(mode string) error {
+	if mode != "" && mode != cloudEventsBinary && mode != cloudEventsStructured {
+		return fmt.Errorf("Unknown CloudEvents mode: %s", mode)
+	}
+
+	return nil
+}
+
+// creates an event of the given type, the time is now
+func newCloudEvent(eventType, source, id, subject string) cloudEvent {
+	return cloudEvent{
+		SpecVersion: cloudEventsVersion,
+		Type:        eventType,
+		Source:      source,
+		ID:          id,
+		Time:        time.Now().UTC(),
+		Subject:     subject,
+	}
+}
+
+// returns the CloudEvents mode of the webhook, the server default if unset
+func (s *Server) cloudEventsMode(hook webhook) string {
+	if hook.CloudEvents != "" {
+		return hook.CloudEvents
+	}
+
+	return s.cloudEvents
+}
+
+// Returns the rates updated event of the current rates. The source names
+// the provider and the id is the version of the rates.
+func (s *Server) ratesEvent(base string) cloudEvent {
+	s.rateMutex.RLock()
+	defer s.rateMutex.RUnlock()
+
+	return newCloudEvent(ratesUpdatedEvent, "/providers/"+s.provider, s.version, base)
+}
+
+// Encodes the data of the given content type as the event in the mode.
+// Returns the body and its headers. Structured events embed JSON data as
+// is and other data as a string.
+func (e cloudEvent) encode(mode string, data []byte, contentType string) (body []byte, header http.Header, err error) {
+	header = http.Header{}
+	if mode == cloudEventsBinary {
+		header.Set("Content-Type", contentType)
+		header.Set("ce-specversion", e.SpecVersion)
+		header.Set("ce-type", e.Type)
+		header.Set("ce-source", e.Source)
+		header.Set("ce-id", e.ID)
+		header.Set("ce-time", e.Time.Format(time.RFC3339Nano))
+		if e.Subject != "" {
+			header.Set("ce-subject", e.Subject)
+		}
+
+		return data, header, nil
+	}
+
+	e.DataContentType = contentType
+	if strings.HasPrefix(contentType, "application/json") {
+		e.Data = json.RawMessage(data)
+	} else {
+		e.Data = string(data)
+	}
+
+	body, err = json.Marshal(e)
+	header.Set("Content-Type", cloudEventsContentType)
+	return body, header, err
+}
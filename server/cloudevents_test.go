@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCloudEventEncode(t *testing.T) {
+	e := newCloudEvent(ratesUpdatedEvent, "/providers/ecb", "abc", "USD")
+
+	body, header, err := e.encode(cloudEventsBinary, []byte("a,b"), "text/csv")
+	if err != nil || string(body) != "a,b" || header.Get("Content-Type") != "text/csv" ||
+		header.Get("ce-specversion") != "1.0" || header.Get("ce-id") != "abc" || header.Get("ce-subject") != "USD" {
+		t.Fatal("Unexpected binary event:", header, err)
+	}
+
+	body, header, err = e.encode(cloudEventsStructured, []byte(`{"a":1}`), "application/json")
+	var structured map[string]interface{}
+	if err != nil || header.Get("Content-Type") != cloudEventsContentType || json.Unmarshal(body, &structured) != nil {
+		t.Fatal("Unexpected structured event:", string(body), err)
+	}
+
+	if structured["data"].(map[string]interface{})["a"] != 1.0 || structured["datacontenttype"] != "application/json" {
+		t.Fatal("Expected the JSON data to be embedded:", structured)
+	}
+
+	body, _, _ = e.encode(cloudEventsStructured, []byte("a,b"), "text/csv")
+	json.Unmarshal(body, &structured)
+	if structured["data"] != "a,b" {
+		t.Fatal("Expected the data as a string:", structured)
+	}
+}
+
+func TestWebhookCloudEvents(t *testing.T) {
+	var header http.Header
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(r.URL.Query().Get("hub.challenge")))
+			return
+		}
+
+		header = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+	defer func() {
+		server.mutex.Lock()
+		delete(server.webhooks, ts.URL)
+		server.mutex.Unlock()
+	}()
+
+	r := fireReq("/webhook", http.MethodPost, webhook{BaseCurrency: "DKK", Url: ts.URL, CloudEvents: cloudEventsBinary})
+	expect(t, r, http.StatusOK, true, nil)
+
+	var res currencyResponse
+	if header.Get("Ce-Type") != ratesUpdatedEvent || header.Get("Ce-Id") != server.version ||
+		header.Get("Ce-Source") != "/providers/"+server.provider || json.Unmarshal(body, &res) != nil || res.BaseCurrency != "DKK" {
+		t.Fatal("Unexpected binary event:", header, string(body))
+	}
+
+	r = fireReq("/webhook", http.MethodPost, webhook{BaseCurrency: "DKK", Url: ts.URL, CloudEvents: cloudEventsStructured})
+	expect(t, r, http.StatusOK, true, nil)
+
+	var event struct {
+		cloudEvent
+		Data currencyResponse `json:"data"`
+	}
+	if header.Get("Content-Type") != cloudEventsContentType || json.Unmarshal(body, &event) != nil {
+		t.Fatal("Unexpected structured event:", header, string(body))
+	}
+
+	if event.Type != ratesUpdatedEvent || event.ID != server.version || event.Subject != "DKK" || event.Data.BaseCurrency != "DKK" {
+		t.Fatal("Unexpected structured event:", string(body))
+	}
+
+	r = fireReq("/webhook", http.MethodPost, webhook{BaseCurrency: "DKK", Url: ts.URL, CloudEvents: "batched"})
+	expect(t, r, http.StatusBadRequest, true, nil)
+}
+
+func TestNotifyCloudEvents(t *testing.T) {
+	events := make(chan http.Header, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events <- r.Header
+	}))
+	defer ts.Close()
+
+	s := &Server{notifyUrl: ts.URL, cloudEvents: cloudEventsBinary, logger: slog.Default()}
+	s.notifyDisabled(disabledNotification{Event: "webhook.disabled", Url: "http://example.com"})
+
+	header := <-events
+	if header.Get("Ce-Type") != webhookDisabledEvent || header.Get("Ce-Subject") != "http://example.com" || header.Get("Content-Type") != "application/json" {
+		t.Fatal("Unexpected notification event:", header)
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// the supported content encodings in order of preference, deflate is the
+// zlib format (RFC 1950). Brotli is not supported, the standard library has
+// no encoder and the server has no dependencies.
+var contentEncodings = []string{"gzip", "deflate"}
+
+// A response writer compressing the body with the content encoding. The
+// compressor is created when the header is written so responses without a
+// body (eg. not modified) are left alone.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	writer      io.WriteCloser
+	wroteHeader bool
+}
+
+// Returns a response writer compressing with the best content encoding the
+// client accepts, or the writer itself if it accepts none.
+func newCompressWriter(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		return w
+	}
+
+	return &compressWriter{ResponseWriter: w, encoding: encoding}
+}
+
+// Returns the preferred supported encoding of the Accept-Encoding header,
+// empty if none is accepted.
+func acceptedEncoding(header string) string {
+	best, bestQuality := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		quality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		for _, encoding := range contentEncodings {
+			if (name == encoding || name == "*") && quality > bestQuality {
+				best, bestQuality = encoding, quality
+				break
+			}
+		}
+	}
+
+	return best
+}
+
+// Returns the strong ETag of the representation in the content encoding,
+// "tag" becomes "tag-gzip". Weak tags are left as they are.
+func encodedEtag(etag, encoding string) string {
+	if strings.HasPrefix(etag, "W/") || !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+
+	if code != http.StatusNoContent && c.Header().Get("Content-Encoding") == "" {
+		// the compressed representation has its own tag, not modified
+		// answers carry the tag of the representation the client has
+		if etag := c.Header().Get("ETag"); etag != "" {
+			c.Header().Set("ETag", encodedEtag(etag, c.encoding))
+		}
+
+		if code != http.StatusNotModified {
+			c.Header().Del("Content-Length")
+			c.Header().Set("Content-Encoding", c.encoding)
+
+			if c.encoding == "gzip" {
+				c.writer = gzip.NewWriter(c.ResponseWriter)
+			} else {
+				c.writer = zlib.NewWriter(c.ResponseWriter)
+			}
+		}
+	}
+
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		// sniff the content type from the uncompressed data
+		if c.Header().Get("Content-Type") == "" {
+			c.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		c.WriteHeader(http.StatusOK)
+	}
+
+	if c.writer == nil {
+		return c.ResponseWriter.Write(b)
+	}
+
+	return c.writer.Write(b)
+}
+
+// flushes the compressed data, must be called when the response is done
+func (c *compressWriter) Close() error {
+	if c.writer == nil {
+		return nil
+	}
+
+	return c.writer.Close()
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                      "",
+		"br":                    "",
+		"gzip":                  "gzip",
+		"deflate, gzip":         "deflate",
+		"deflate;q=0.5, gzip":   "gzip",
+		"*":                     "gzip",
+		"gzip;q=0, deflate":     "deflate",
+		"gzip;q=0, deflate;q=0": "",
+	}
+
+	for header, expected := range cases {
+		if acceptedEncoding(header) != expected {
+			t.Fatal("Unexpected encoding:", header, acceptedEncoding(header))
+		}
+	}
+}
+
+func TestCompressedResponse(t *testing.T) {
+	readers := map[string]func(io.Reader) (io.Reader, error){
+		"gzip":    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"deflate": func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+	}
+
+	for encoding, reader := range readers {
+		r := fireReqHeaders("/currencies", http.MethodGet, nil, map[string]string{"Accept-Encoding": encoding})
+		expect(t, r, http.StatusOK, true, nil)
+
+		if r.Header().Get("Content-Encoding") != encoding {
+			t.Fatal("Unexpected encoding:", r.Header().Get("Content-Encoding"))
+		}
+
+		body, err := reader(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var res currencyResponse
+		err = json.NewDecoder(body).Decode(&res)
+		if err != nil || res.BaseCurrency != eur {
+			t.Fatal("Unexpected response:", res, err)
+		}
+	}
+}
+
+func TestCompressedNotModified(t *testing.T) {
+	r := fireReqHeaders("/currencies", http.MethodGet, nil, map[string]string{"Accept-Encoding": "gzip", "If-None-Match": "*"})
+	expect(t, r, http.StatusNotModified, true, nil)
+
+	if r.Header().Get("Content-Encoding") != "" || r.Body.Len() != 0 {
+		t.Fatal("Expected an uncompressed empty response")
+	}
+}
+
+func TestCompressedEtag(t *testing.T) {
+	r := fireReq("/currencies", http.MethodGet, nil)
+	etag := r.Header().Get("ETag")
+
+	r = fireReqHeaders("/currencies", http.MethodGet, nil, map[string]string{"Accept-Encoding": "gzip"})
+	gzipEtag := r.Header().Get("ETag")
+	if gzipEtag != encodedEtag(etag, "gzip") || gzipEtag == etag {
+		t.Fatal("Expected another ETag for the compressed response:", etag, gzipEtag)
+	}
+
+	// the tag of the compressed response is revalidated as such
+	r = fireReqHeaders("/currencies", http.MethodGet, nil, map[string]string{"Accept-Encoding": "gzip", "If-None-Match": gzipEtag})
+	expect(t, r, http.StatusNotModified, true, nil)
+	if r.Header().Get("ETag") != gzipEtag {
+		t.Fatal("Unexpected ETag:", r.Header().Get("ETag"))
+	}
+
+	r = fireReqHeaders("/currencies", http.MethodGet, nil, map[string]string{"If-None-Match": gzipEtag})
+	expect(t, r, http.StatusNotModified, true, nil)
+	if r.Header().Get("ETag") != etag {
+		t.Fatal("Unexpected ETag:", r.Header().Get("ETag"))
+	}
+
+	if encodedEtag(`W/"tag"`, "gzip") != `W/"tag"` {
+		t.Fatal("Expected weak tags to be left alone")
+	}
+}
@@ -0,0 +1,410 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the sources of the configuration values, later sources take precedence
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// A configuration setting. Every setting can be set in the configuration
+// file by its key and in the environment. Settings that are not secret can
+// also be set as a command-line flag, secrets would be visible in the
+// process arguments.
+type ConfigSetting struct {
+	Key     string // the key in the configuration file
+	Flag    string // the name of the command-line flag, empty for secrets
+	Env     string // the environment variable
+	Default string
+	Usage   string
+	Secret  bool // the value is redacted when printed and has no flag
+}
+
+// the settings in the order they are printed
+var configSettings = []ConfigSetting{
+	{Key: "host", Env: HostEnvironment, Default: defaultHost, Usage: "hostname to listen on"},
+	{Key: "port", Env: PortEnvironment, Default: defaultPort, Usage: "port to listen on"},
+	{Key: "providers", Env: ProvidersEnvironment, Default: defaultProviders, Usage: "ordered list of rate providers, eg. ecb,frankfurter=URL"},
+	{Key: "ecb_url", Env: EcbUrlEnvironment, Default: ecbCurrencyUrl, Usage: "URL of the ECB reference rates"},
+	{Key: "stale_after", Env: StaleAfterEnvironment, Default: defaultStaleAfter, Usage: "max rate age before failing over to the next provider"},
+	{Key: "tolerance", Env: ToleranceEnvironment, Default: "0", Usage: "cross-check tolerance of the providers in percent, 0 disables"},
+	{Key: "update_interval", Env: UpdateIntervalEnvironment, Default: defaultUpdateInterval, Usage: "time between rate updates"},
+	{Key: "retry_interval", Env: RetryIntervalEnvironment, Default: defaultRetryInterval, Usage: "time before retrying a failed rate update"},
+	{Key: "fetch_timeout", Env: FetchTimeoutEnvironment, Default: defaultFetchTimeout, Usage: "timeout of a single provider fetch"},
+	{Key: "history", Env: HistoryEnvironment, Default: ecbHistoryUrl, Usage: "URL to backfill the history from, none disables"},
+	{Key: "default_base", Env: DefaultBaseEnvironment, Default: eur, Usage: "base currency of /currencies without a base"},
+	{Key: "admin_token", Env: AdminTokenEnvironment, Usage: "token of the admin API, empty disables it", Secret: true},
+	{Key: "fees", Env: FeesEnvironment, Usage: "fee profiles file"},
+	{Key: "cors_origins", Env: CorsOriginsEnvironment, Usage: "allowed CORS origins, empty disables CORS"},
+	{Key: "cors_methods", Env: CorsMethodsEnvironment, Default: defaultCorsMethods, Usage: "allowed CORS methods"},
+	{Key: "cors_headers", Env: CorsHeadersEnvironment, Default: defaultCorsHeaders, Usage: "allowed CORS request headers"},
+	{Key: "cors_max_age", Env: CorsMaxAgeEnvironment, Default: defaultCorsMaxAge, Usage: "max age of CORS preflights"},
+	{Key: "jsonp", Env: JsonpEnvironment, Default: "false", Usage: "allow JSONP callbacks"},
+	{Key: "require_keys", Env: RequireKeysEnvironment, Default: "false", Usage: "require API keys"},
+	{Key: "keys_file", Env: KeysFileEnvironment, Usage: "API keys file, empty keeps the keys in memory"},
+	{Key: "key_rate", Env: KeyRateEnvironment, Default: defaultKeyRate, Usage: "default requests per minute of an API key"},
+	{Key: "webhook_schemes", Env: WebhookSchemesEnvironment, Default: defaultWebhookSchemes, Usage: "allowed webhook URL schemes"},
+	{Key: "webhook_allow", Env: WebhookAllowEnvironment, Usage: "allowed webhook hosts and ranges"},
+	{Key: "webhook_deny", Env: WebhookDenyEnvironment, Usage: "denied webhook hosts and ranges"},
+	{Key: "webhook_timeout", Env: WebhookTimeoutEnvironment, Default: defaultWebhookTimeout, Usage: "timeout of a webhook call"},
+	{Key: "webhook_max_failures", Env: WebhookMaxFailuresEnvironment, Default: defaultWebhookMaxFailures, Usage: "failures before a webhook is disabled, 0 never disables"},
+	{Key: "notify_url", Env: NotifyUrlEnvironment, Usage: "URL notified about disabled webhooks"},
+	{Key: "cloudevents", Env: CloudEventsEnvironment, Usage: "default CloudEvents mode of the push channels (binary or structured)"},
+	{Key: "ready_max_age", Env: ReadyMaxAgeEnvironment, Default: defaultReadyMaxAge, Usage: "time rates may be overdue before the server is not ready"},
+	{Key: "log_level", Env: LogLevelEnvironment, Default: defaultLogLevel, Usage: "log level (debug, info, warn or error)"},
+	{Key: "log_format", Env: LogFormatEnvironment, Default: defaultLogFormat, Usage: "log format (json or text)"},
+	{Key: "trace_exporter", Env: TraceExporterEnvironment, Usage: "trace span exporter (stdout), empty disables"},
+}
+
+// Returns the configuration settings, the flag names are the keys with
+// dashes. Secret settings have no flag.
+func ConfigSettings() []ConfigSetting {
+	settings := make([]ConfigSetting, len(configSettings))
+	for i, setting := range configSettings {
+		if !setting.Secret {
+			setting.Flag = strings.ReplaceAll(setting.Key, "_", "-")
+		}
+		settings[i] = setting
+	}
+
+	return settings
+}
+
+// returns the setting of the key
+func configSetting(key string) (ConfigSetting, bool) {
+	for _, setting := range configSettings {
+		if setting.Key == key {
+			return setting, true
+		}
+	}
+
+	return ConfigSetting{}, false
+}
+
+// The configuration of a server. It starts with the defaults and is layered
+// with the file, the environment and the flags, in this order.
+type Config struct {
+	values  map[string]string
+	sources map[string]string
+}
+
+// Returns the default configuration.
+func DefaultConfig() *Config {
+	c := &Config{values: make(map[string]string), sources: make(map[string]string)}
+	for _, setting := range configSettings {
+		c.values[setting.Key], c.sources[setting.Key] = setting.Default, sourceDefault
+	}
+
+	return c
+}
+
+// sets the value of the key from the source, errors on unknown keys
+func (c *Config) set(key, value, source string) error {
+	if _, found := configSetting(key); !found {
+		return fmt.Errorf("Unknown configuration key: %s", key)
+	}
+
+	c.values[key], c.sources[key] = value, source
+	return nil
+}
+
+// Sets the value of the key, eg. from a command-line flag. Secret settings
+// can only be set in the configuration file or the environment.
+func (c *Config) Set(key, value string) error {
+	if setting, found := configSetting(key); found && setting.Secret {
+		return fmt.Errorf("Secret setting %s cannot be set as a flag", key)
+	}
+
+	return c.set(key, value, sourceFlag)
+}
+
+// returns the value of the key
+func (c *Config) get(key string) string {
+	return c.values[key]
+}
+
+// Sets the values of the environment variables that are set and not empty.
+func (c *Config) LoadEnv() {
+	for _, setting := range configSettings {
+		if value := os.Getenv(setting.Env); value != "" {
+			c.set(setting.Key, value, sourceEnv)
+		}
+	}
+}
+
+// Sets the values of the configuration file. The format follows from the
+// extension: .json, .yaml/.yml or .toml. An empty path loads nothing.
+func (c *Config) LoadFile(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Error reading configuration: %s", err)
+	}
+	defer f.Close()
+
+	var values map[string]string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		values, err = parseJsonConfig(f)
+	case ".yaml", ".yml":
+		values, err = parseConfigLines(f, ":")
+	case ".toml":
+		values, err = parseConfigLines(f, "=")
+	default:
+		return fmt.Errorf("Unknown configuration format: %s", path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Error parsing %s: %s", path, err)
+	}
+
+	for key, value := range values {
+		if err = c.set(key, value, sourceFile); err != nil {
+			return fmt.Errorf("Error parsing %s: %s", path, err)
+		}
+	}
+
+	return nil
+}
+
+// Writes the configuration with the source of every value. The output is a
+// valid TOML configuration file, except for redacted secrets.
+func (c *Config) Print(w io.Writer) {
+	for _, setting := range configSettings {
+		value := c.values[setting.Key]
+		if setting.Secret && value != "" {
+			value = "<redacted>"
+		}
+
+		fmt.Fprintf(w, "%s = %s # %s\n", setting.Key, strconv.Quote(value), c.sources[setting.Key])
+	}
+}
+
+// Returns the logger writing to out in the configured log format and level.
+func (c *Config) Logger(out io.Writer) (*slog.Logger, error) {
+	return newLogger(out, c.get("log_format"), c.get("log_level"))
+}
+
+// parses the duration of the key, it must be positive
+func (c *Config) duration(key string) (time.Duration, error) {
+	d, err := time.ParseDuration(c.get(key))
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("Error parsing %s: %s", key, c.get(key))
+	}
+
+	return d, nil
+}
+
+// parses the boolean of the key, true or false
+func (c *Config) boolean(key string) (bool, error) {
+	switch c.get(key) {
+	case "true":
+		return true, nil
+	case "false", "":
+		return false, nil
+	}
+
+	return false, fmt.Errorf("Error parsing %s: %s", key, c.get(key))
+}
+
+// parses a flat JSON object, lists are joined by commas
+func parseJsonConfig(r io.Reader) (map[string]string, error) {
+	var raw map[string]interface{}
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for key, v := range raw {
+		value, err := jsonConfigValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err)
+		}
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+// returns the JSON value as a setting value
+func jsonConfigValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			if _, nested := item.([]interface{}); nested {
+				return "", fmt.Errorf("Nested lists are not supported")
+			}
+
+			value, err := jsonConfigValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = value
+		}
+		return strings.Join(items, ","), nil
+	}
+
+	return "", fmt.Errorf("Nested values are not supported")
+}
+
+// Parses the flat subset of YAML ("key: value") or TOML ("key = value")
+// used for configuration files: one setting per line, comments, quoted
+// strings and lists, in YAML also as "- item" lines below the key.
+func parseConfigLines(r io.Reader, separator string) (map[string]string, error) {
+	values := make(map[string]string)
+	var listKey string // the YAML key the "- item" lines belong to
+	var list []string
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(stripComment(scanner.Text()), " \t")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || (separator == ":" && trimmed == "---") {
+			continue
+		}
+
+		// a YAML list item of the previous key
+		if separator == ":" && strings.HasPrefix(trimmed, "- ") && listKey != "" {
+			item, err := parseConfigValue(trimmed[2:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", n, err)
+			}
+			list = append(list, item)
+			values[listKey] = strings.Join(list, ",")
+			continue
+		}
+		listKey, list = "", nil
+
+		if separator == "=" && strings.HasPrefix(trimmed, "[") {
+			return nil, fmt.Errorf("line %d: Tables are not supported", n)
+		}
+
+		if separator == ":" && strings.TrimLeft(line, " \t") != line {
+			return nil, fmt.Errorf("line %d: Nested values are not supported", n)
+		}
+
+		i := strings.Index(trimmed, separator)
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: Expected key%svalue", n, separator)
+		}
+
+		key := strings.Trim(strings.TrimSpace(trimmed[:i]), `"'`)
+		raw := strings.TrimSpace(trimmed[i+1:])
+		if raw == "" && separator == ":" {
+			// the value may follow as list items
+			listKey, values[key] = key, ""
+			continue
+		}
+
+		value, err := parseConfigValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		values[key] = value
+	}
+
+	return values, scanner.Err()
+}
+
+// Parses a scalar or an inline list ([a, "b"]), lists are joined by commas.
+// Double quoted strings are unescaped, single quoted ones taken literally.
+func parseConfigValue(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case strings.HasPrefix(raw, "["):
+		if !strings.HasSuffix(raw, "]") {
+			return "", fmt.Errorf("Unterminated list: %s", raw)
+		}
+
+		var items []string
+		for _, item := range splitQuoted(raw[1 : len(raw)-1]) {
+			if strings.TrimSpace(item) == "" {
+				continue
+			}
+
+			value, err := parseConfigValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, value)
+		}
+		return strings.Join(items, ","), nil
+	case strings.HasPrefix(raw, `"`):
+		value, err := strconv.Unquote(raw)
+		if err != nil {
+			return "", fmt.Errorf("Invalid string: %s", raw)
+		}
+		return value, nil
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", fmt.Errorf("Invalid string: %s", raw)
+		}
+		return strings.ReplaceAll(raw[1:len(raw)-1], "''", "'"), nil
+	case raw == "null" || raw == "~":
+		return "", nil
+	}
+
+	return raw, nil
+}
+
+// removes a comment starting with # outside of quotes
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+// splits the list items at commas outside of quotes
+func splitQuoted(s string) (items []string) {
+	var quote rune
+	start := 0
+	for i, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(items, s[start:])
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writes the configuration file to a temporary directory
+func writeConfig(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestConfigFormats(t *testing.T) {
+	for name, content := range map[string]string{
+		"config.json": `{"port": 4100, "providers": ["ecb", "frankfurter"], "jsonp": true, "cors_origins": "https://a.example.com"}`,
+		"config.yaml": `---
+# the rates
+port: 4100 # not the default
+providers:
+  - ecb
+  - 'frankfurter'
+jsonp: true
+cors_origins: "https://a.example.com"
+`,
+		"config.toml": `# the rates
+port = 4100
+providers = ["ecb", "frankfurter"] # in order
+jsonp = true
+cors_origins = 'https://a.example.com'
+`,
+	} {
+		c := DefaultConfig()
+		if err := c.LoadFile(writeConfig(t, name, content)); err != nil {
+			t.Fatal(name, err)
+		}
+
+		if c.get("port") != "4100" || c.get("providers") != "ecb,frankfurter" || c.get("jsonp") != "true" ||
+			c.get("cors_origins") != "https://a.example.com" || c.get("host") != defaultHost {
+			t.Fatal("Unexpected configuration:", name, c.values)
+		}
+	}
+
+	for name, content := range map[string]string{
+		"unknown.json": `{"colour": "blue"}`,
+		"nested.json":  `{"cors": {"origins": "*"}}`,
+		"nested.yaml":  "cors:\n  origins: '*'\n",
+		"table.toml":   "[cors]\norigins = '*'\n",
+		"broken.toml":  "port 4100\n",
+		"config.ini":   "port=4100\n",
+	} {
+		if err := DefaultConfig().LoadFile(writeConfig(t, name, content)); err == nil {
+			t.Fatal("Expected an error:", name)
+		}
+	}
+}
+
+func TestConfigLayers(t *testing.T) {
+	defer os.Unsetenv(HostEnvironment)
+	defer os.Unsetenv(PortEnvironment)
+	os.Setenv(HostEnvironment, "0.0.0.0")
+	os.Setenv(PortEnvironment, "4200")
+
+	c := DefaultConfig()
+	c.LoadFile(writeConfig(t, "config.toml", "port = 4100\ntolerance = 0.5\nadmin_token = \"secret\"\n"))
+
+	// the admin token of the tests is set in the environment
+	c.LoadEnv()
+	c.Set("port", "4300")
+
+	b := &strings.Builder{}
+	c.Print(b)
+	for _, line := range []string{
+		`host = "0.0.0.0" # env`,
+		`port = "4300" # flag`,
+		`tolerance = "0.5" # file`,
+		`stale_after = "144h" # default`,
+		`admin_token = "<redacted>" # env`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Fatal("Expected the line:", line, b.String())
+		}
+	}
+
+	if err := c.Set("colour", "blue"); err == nil {
+		t.Fatal("Expected an error for an unknown key")
+	}
+
+	// secrets are not accepted as flags
+	if err := c.Set("admin_token", "leaked"); err == nil {
+		t.Fatal("Expected an error for a secret flag")
+	}
+
+	for _, setting := range ConfigSettings() {
+		if setting.Secret && setting.Flag != "" {
+			t.Fatal("Unexpected flag of a secret:", setting.Flag)
+		}
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	c := DefaultConfig()
+	c.Set("default_base", "USD")
+	c.Set("update_interval", "10m")
+	c.Set("ecb_url", "https://mirror.example.com/rates.xml")
+	logger, defaultLogger := slog.New(slog.NewTextHandler(io.Discard, nil)), slog.Default()
+	s, err := NewWithConfig(c, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the server logs to the given logger, the default is left alone
+	if s.logger != logger || slog.Default() != defaultLogger {
+		t.Fatal("Unexpected loggers:", s.logger, slog.Default())
+	}
+
+	if s.defaultBase != "USD" || s.updateInterval.Minutes() != 10 || s.providers[0].url != "https://mirror.example.com/rates.xml" {
+		t.Fatal("Unexpected server:", s.defaultBase, s.updateInterval, s.providers)
+	}
+
+	for key, value := range map[string]string{
+		"port":            "http",
+		"update_interval": "-1m",
+		"fetch_timeout":   "soon",
+		"webhook_timeout": "0s",
+		"default_base":    "usd",
+		"ecb_url":         "ftp://example.com",
+		"jsonp":           "yes",
+		"log_level":       "loud",
+		"trace_exporter":  "jaeger",
+	} {
+		c := DefaultConfig()
+		c.Set(key, value)
+		if _, err := NewWithConfig(c, logger); err == nil {
+			t.Fatal("Expected an error:", key, value)
+		}
+	}
+}
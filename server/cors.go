@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCorsMethods = "GET, POST, OPTIONS"      // default allowed methods of cross origin requests
+	defaultCorsHeaders = "Content-Type, X-API-Key" // default allowed headers of cross origin requests
+	defaultCorsMaxAge  = "10m"                     // default time browsers may cache a preflight
+
+	allowedMethods = "GET, HEAD, POST, DELETE, OPTIONS" // methods handled by the server
+)
+
+// the valid JSONP callbacks, a dotted path of JavaScript identifiers
+var jsonpCallback = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+
+// the CORS configuration, no origins disables CORS
+type corsConfig struct {
+	origins []string // allowed origins, "*" allows any origin
+	methods string   // allowed methods, comma separated
+	headers string   // allowed request headers, comma separated
+	maxAge  time.Duration
+}
+
+// Parses the CORS configuration. The origins are a comma separated list of
+// origins (eg. "https://example.com") or "*" to allow any origin.
+func parseCors(origins, methods, headers, maxAge string) (c corsConfig, err error) {
+	for _, origin := range strings.Split(origins, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			return c, fmt.Errorf("Invalid CORS origin: %s", origin)
+		}
+
+		c.origins = append(c.origins, strings.TrimSuffix(origin, "/"))
+	}
+
+	c.maxAge, err = time.ParseDuration(maxAge)
+	if err != nil || c.maxAge < 0 {
+		return c, fmt.Errorf("Error parsing CORS max age: %s", maxAge)
+	}
+
+	c.methods, c.headers = methods, headers
+	return c, nil
+}
+
+// Returns the value of the Access-Control-Allow-Origin header for the origin,
+// empty if the origin is not allowed.
+func (c corsConfig) allowedOrigin(origin string) string {
+	for _, allowed := range c.origins {
+		if allowed == "*" {
+			return "*"
+		}
+
+		if origin != "" && strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+
+	return ""
+}
+
+// Sets the CORS headers of the response and answers OPTIONS requests,
+// including preflights. Returns true if the request has been answered.
+func (s *Server) handleCors(w http.ResponseWriter, r *http.Request) bool {
+	if len(s.cors.origins) > 0 {
+		if s.cors.allowedOrigin("*") == "" {
+			// the response depends on the origin unless any is allowed
+			w.Header().Add("Vary", "Origin")
+		}
+
+		if allowed := s.cors.allowedOrigin(r.Header.Get("Origin")); allowed != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			w.Header().Set("Access-Control-Expose-Headers", "ETag")
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", s.cors.methods)
+				w.Header().Set("Access-Control-Allow-Headers", s.cors.headers)
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(s.cors.maxAge.Seconds())))
+			}
+		}
+	}
+
+	if r.Method != http.MethodOptions {
+		return false
+	}
+
+	w.Header().Set("Allow", allowedMethods)
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseCors(t *testing.T) {
+	c, err := parseCors("https://a.example.com/, http://b.example.com", defaultCorsMethods, defaultCorsHeaders, "1h")
+	if err != nil || len(c.origins) != 2 || c.maxAge.Hours() != 1 {
+		t.Fatal("Unexpected config:", c, err)
+	}
+
+	if c.allowedOrigin("https://a.example.com") != "https://a.example.com" || c.allowedOrigin("https://c.example.com") != "" {
+		t.Fatal("Unexpected allowed origins")
+	}
+
+	for _, origins := range []string{"example.com", "ftp://example.com"} {
+		if _, err := parseCors(origins, "", "", "1h"); err == nil {
+			t.Fatal("Expected an error:", origins)
+		}
+	}
+
+	if _, err := parseCors("*", "", "", "soon"); err == nil {
+		t.Fatal("Expected an error for the max age")
+	}
+}
+
+func TestCorsPreflight(t *testing.T) {
+	defer func(c corsConfig) { server.cors = c }(server.cors)
+	server.cors, _ = parseCors("https://app.example.com", defaultCorsMethods, defaultCorsHeaders, "10m")
+
+	r := fireReqHeaders("/convert", http.MethodOptions, nil, map[string]string{
+		"Origin":                        "https://app.example.com",
+		"Access-Control-Request-Method": "POST",
+	})
+	expect(t, r, http.StatusNoContent, true, nil)
+
+	h := r.Header()
+	if h.Get("Access-Control-Allow-Origin") != "https://app.example.com" || h.Get("Access-Control-Allow-Methods") != defaultCorsMethods ||
+		h.Get("Access-Control-Allow-Headers") != defaultCorsHeaders || h.Get("Access-Control-Max-Age") != "600" {
+		t.Fatal("Unexpected preflight headers:", h)
+	}
+
+	r = fireReqHeaders("/convert", http.MethodOptions, nil, map[string]string{
+		"Origin":                        "https://evil.example.com",
+		"Access-Control-Request-Method": "POST",
+	})
+	expect(t, r, http.StatusNoContent, true, nil)
+	if r.Header().Get("Access-Control-Allow-Origin") != "" || r.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Fatal("Unexpected CORS headers for a disallowed origin")
+	}
+}
+
+func TestCorsRequest(t *testing.T) {
+	defer func(c corsConfig) { server.cors = c }(server.cors)
+
+	r := fireReqHeaders("/currencies", http.MethodGet, nil, map[string]string{"Origin": "https://app.example.com"})
+	expect(t, r, http.StatusOK, true, nil)
+	if r.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("Expected CORS to be disabled")
+	}
+
+	server.cors, _ = parseCors("*", defaultCorsMethods, defaultCorsHeaders, "10m")
+	r = fireReqHeaders("/currencies", http.MethodGet, nil, map[string]string{"Origin": "https://app.example.com"})
+	expect(t, r, http.StatusOK, true, nil)
+	if r.Header().Get("Access-Control-Allow-Origin") != "*" || r.Header().Get("Access-Control-Expose-Headers") != "ETag" {
+		t.Fatal("Unexpected CORS headers:", r.Header())
+	}
+}
+
+func TestJsonp(t *testing.T) {
+	defer func(jsonp bool) { server.jsonp = jsonp }(server.jsonp)
+
+	r := fireReq("/currencies?callback=handle", http.MethodGet, nil)
+	if strings.Contains(r.Body.String(), "handle(") {
+		t.Fatal("Expected JSONP to be disabled")
+	}
+
+	server.jsonp = true
+	r = fireReq("/currencies?callback=app.handle", http.MethodGet, nil)
+	expect(t, r, http.StatusOK, true, nil)
+	if r.Header().Get("Content-Type") != "text/javascript; charset=utf-8" || !strings.HasPrefix(r.Body.String(), "/**/ app.handle({") {
+		t.Fatal("Unexpected JSONP response:", r.Body.String())
+	}
+
+	r = fireReq("/currencies?callback=alert(1)", http.MethodGet, nil)
+	expect(t, r, http.StatusBadRequest, true, nil)
+
+	r = fireReq("/currencies?callback=handle&format=csv", http.MethodGet, nil)
+	expect(t, r, http.StatusOK, true, nil)
+	if strings.Contains(r.Body.String(), "handle(") {
+		t.Fatal("Expected no callback for CSV")
+	}
+}
@@ -83,3 +83,39 @@ func TestCreateInvalidCurrencyResponse(t *testing.T) {
 		t.Fatal("Currency shouldn't be known: FOO")
 	}
 }
+
+func TestConvertResponseRate(t *testing.T) {
+	response, err := server.createConvertResponse("DKK", "USD", []float64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Rate*10 != response.ConvertedAmounts[0] {
+		t.Fatal("Unexpected rate:", response.Rate)
+	}
+
+	if fmt.Sprintf("%.6f", response.Rate*response.InverseRate) != "1.000000" {
+		t.Fatal("Unexpected inverse rate:", response.InverseRate)
+	}
+
+	if response.RateVersion == "" || response.RateVersion != server.version {
+		t.Fatal("Unexpected rate version:", response.RateVersion)
+	}
+}
+
+func TestRateVersion(t *testing.T) {
+	ts := server.lastUpdateTime
+	rates := map[string]float64{"EUR": 1, "USD": 1.1}
+	v := rateVersion(ts, rates)
+	if v != rateVersion(ts, map[string]float64{"USD": 1.1, "EUR": 1}) {
+		t.Fatal("Expected the same version for the same rates")
+	}
+
+	if v == rateVersion(ts, map[string]float64{"EUR": 1, "USD": 1.2}) {
+		t.Fatal("Expected a new version for new rates")
+	}
+
+	if v == rateVersion(ts.AddDate(0, 0, 1), rates) {
+		t.Fatal("Expected a new version for a new date")
+	}
+}
@@ -1,12 +1,20 @@
 package server
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"sort"
+	"time"
 )
 
 // Takes a string identifying a currency and returns a container with
-// the known rates relative to the given base.
+// the known rates relative to the given base. The rates are read under the
+// rate lock as the overrides change them at any time.
 func (s *Server) createResponse(base string) (r *currencyResponse, err error) {
+	s.rateMutex.RLock()
+	defer s.rateMutex.RUnlock()
+
 	// return if we don't have any currencies (job might still be fetching)
 	if !s.hasCurrencies {
 		return nil, fmt.Errorf("Currencies not fetched")
@@ -22,13 +30,17 @@ func (s *Server) createResponse(base string) (r *currencyResponse, err error) {
 	response := currencyResponse{}
 	response.BaseCurrency = base
 	response.CurrencyDate = s.lastUpdateTime.Format("2006-01-02")
+	response.RateVersion = s.version
+	response.Provider = s.provider
 
 	// fill the converted rates
 	for name, rate := range s.currencies {
 		relativeRate := rate / baserate
 		r := rateResponse{
-			Name: name,
-			Rate: relativeRate,
+			Name:       name,
+			Rate:       relativeRate,
+			Source:     s.rateSource(name),
+			Mismatches: s.mismatches[name],
 		}
 
 		response.Rates = append(response.Rates, r)
@@ -40,11 +52,26 @@ func (s *Server) createResponse(base string) (r *currencyResponse, err error) {
 // Takes a base currency and a target currency and converts a slice of amounts
 // from one to another.
 func (s *Server) createConvertResponse(to, from string, amounts []float64) (r *convertResponse, err error) {
+	s.rateMutex.RLock()
+	defer s.rateMutex.RUnlock()
+
 	// create the response package
 	response := convertResponse{}
 	response.BaseCurrency = from
 	response.TargetCurrency = to
 	response.CurrencyDate = s.lastUpdateTime.Format("2006-01-02")
+	response.Provider = s.provider
+	response.Mismatches = s.mismatching(from, to)
+	response.Overrides = s.overridden(from, to)
+	response.RateVersion = s.version
+
+	// the effective rate and its inverse, fails on unknown currencies
+	rate, err := s.convert(to, from, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	response.Rate, response.InverseRate = rate, 1/rate
 
 	// convert the amounts, one at a time
 	for _, amount := range amounts {
@@ -61,21 +88,52 @@ func (s *Server) createConvertResponse(to, from string, amounts []float64) (r *c
 	return &response, nil
 }
 
-// Converts a single amount from one currency to another
+// Converts a single amount from one currency to another, must be called
+// while holding the rate lock
 func (s *Server) convert(to, from string, amount float64) (result float64, err error) {
+	rate, err := crossRate(s.currencies, to, from)
+	if err != nil {
+		return 0.0, err
+	}
+
+	// convert!
+	result = amount * rate
+	return result, nil
+}
+
+// Returns the rate for converting from one currency to another using the
+// given EUR based rates.
+func crossRate(currencies map[string]float64, to, from string) (rate float64, err error) {
 	// error if base currency is not known
-	baserate, found := s.currencies[from]
+	baserate, found := currencies[from]
 	if !found {
 		return 0.0, fmt.Errorf("Unknown currency: %s", from)
 	}
 
 	// error if target currency is not known
-	targetrate, found := s.currencies[to]
+	targetrate, found := currencies[to]
 	if !found {
 		return 0.0, fmt.Errorf("Unknown currency: %s", to)
 	}
 
-	// convert!
-	result = amount / baserate * targetrate
-	return result, nil
+	return targetrate / baserate, nil
+}
+
+// Returns the version of a rate set. The version is derived from the date
+// and the rates so the same rates always have the same version.
+func rateVersion(ts time.Time, currencies map[string]float64) string {
+	names := make([]string, 0, len(currencies))
+	for name := range currencies {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	h := sha256.New()
+	fmt.Fprintln(h, ts.Format(currencyDateFormat))
+	for _, name := range names {
+		fmt.Fprintf(h, "%s=%v\n", name, currencies[name])
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:8])
 }
@@ -1,62 +1,103 @@
 package server
 
 import (
+	"context"
 	"encoding/xml"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"time"
 )
 
 const (
-	errorSleepTime   = 1 * time.Minute // The sleep time after an error
-	successSleepTime = 1 * time.Hour   // The standard sleep time
+	defaultRetryInterval  = "1m"  // The sleep time after an error
+	defaultUpdateInterval = "1h"  // The standard sleep time
+	defaultFetchTimeout   = "30s" // The timeout for a single provider fetch
 
 	ecbCurrencyUrl     = "http://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"
 	currencyDateFormat = "2006-01-02" // The time format in ECB XML
 	eur                = "EUR"        // The euro symbol
 )
 
-// Starts a goroutine what fetches the currencies from the ECB every hour
+// client used for fetching from the providers, every fetch has a deadline
+// as a hanging provider would otherwise block the failover
+var fetchClient = &http.Client{}
+
+// Starts a goroutine what fetches the currencies from the providers every hour
 func (s *Server) startCurrencyUpdating() {
-	log.Println("Starting currency fetching...")
+	logger := s.logger.With("component", "updater")
+	logger.Info("Starting currency fetching")
 	go func() {
-		for {
-			log.Println("Starting new currency fetch...")
-
-			// initialize the default nap time
-			napTime := successSleepTime
+		backfilled := s.historyUrl == ""
+		for run := 1; ; run++ {
+			logger := logger.With("run", run)
+			logger.Debug("Starting new currency fetch")
 
-			if data, err := fetchCurrencyData(); err == nil {
-				if ts, curr, err2 := parseCurrencyData(data); err2 == nil {
-					// everything succeeded - update the currency data
-					// lock while doing so
-					s.mutex.Lock()
-					s.hasCurrencies, s.lastUpdateTime, s.currencies = true, ts, curr
-					s.mutex.Unlock()
+			// every run is a trace, the webhook calls included
+			ctx, span := startSpan(s.tracer.context(context.Background(), nil), "rates.update", spanInternal)
+			span.set("update.run", run)
 
-					log.Println("Currencies updated.")
+			// initialize the default nap time
+			napTime := s.updateInterval
 
-					// call the webhooks
-					go s.callWebhooks()
+			// backfill the history until it succeeds once
+			if !backfilled {
+				if err := s.backfillHistory(ctx, s.historyUrl, s.fetchTimeout); err == nil {
+					backfilled = true
+					logger.Info("History backfilled", "days", s.history.len())
 				} else {
-					// error occured - log and set smaller nap time
-					log.Println("Error parsing currency data:", err)
-					napTime = errorSleepTime
+					logger.Warn("Error backfilling history", "error", err)
 				}
+			}
+
+			set, err := s.fetchRates(ctx)
+			if err == nil {
+				// everything succeeded - update the currency data
+				s.setRates(set)
+
+				logger.Info("Currencies updated", "provider", set.provider, "rate_date", set.time.Format(currencyDateFormat),
+					"currencies", len(set.currencies), "mismatches", len(set.mismatches))
+
+				// call the webhooks
+				go s.callWebhooks(ctx)
 			} else {
 				// error occured - log and set smaller nap time
-				log.Println("Error fetching currency data:", err)
-				napTime = errorSleepTime
+				logger.Error("Error fetching currency data", "error", err)
+				s.fetchFailed(err)
+				napTime = s.retryInterval
 			}
 
+			span.end(err)
+
 			// nap
-			log.Println("Sleeping", napTime)
+			logger.Debug("Sleeping", "duration", napTime.String())
 			time.Sleep(napTime)
 		}
 	}()
 }
 
+// Updates the currency data with the given rate set, locks while doing so.
+func (s *Server) setRates(set *rateSet) {
+	s.rateMutex.Lock()
+	s.hasCurrencies, s.lastUpdateTime, s.feed = true, set.time, set.currencies
+	s.lastFetch = time.Now()
+	s.provider, s.mismatches = set.provider, set.mismatches
+	s.mergeRates()
+	s.rateMutex.Unlock()
+
+	s.history.add(set.time, set.currencies)
+	s.providerName.Set(set.provider)
+	s.rateMismatches.Set(int64(len(set.mismatches)))
+}
+
+// returns true once rates have been fetched
+func (s *Server) ratesLoaded() bool {
+	s.rateMutex.RLock()
+	defer s.rateMutex.RUnlock()
+
+	return s.hasCurrencies
+}
+
 // The currency XML data
 type currencyEnvelope struct {
 	Sender string `xml:"Sender>name"`
@@ -79,12 +120,32 @@ type cube struct {
 	Rate float64 `xml:"rate,attr"`
 }
 
-// Fetches the raw data from the ECB URL.
-func fetchCurrencyData() (data []byte, err error) {
-	res, err := http.Get(ecbCurrencyUrl)
+// Fetches the raw data from the given provider URL.
+func fetchCurrencyData(ctx context.Context, url string) (data []byte, err error) {
+	ctx, span := startSpan(ctx, "rates.fetch", spanClient)
+	span.set("http.url", url)
+	defer func() {
+		span.set("http.response_size", len(data))
+		span.end(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
+	injectTrace(ctx, req.Header)
+
+	res, err := fetchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	span.set("http.status_code", res.StatusCode)
+
+	// anything but 200 is not currency data
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("Unexpected status from %s: %d", url, res.StatusCode)
+	}
 
 	data, err = ioutil.ReadAll(res.Body)
 	res.Body.Close() // ignore error?
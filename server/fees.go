@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+// the fee for a conversion, the spread is a markup on the mid rate and the
+// fee is a percentage of the converted amount with a fixed minimum
+type feeRule struct {
+	Spread  float64 `json:"spread"`  // markup on the mid rate in percent
+	Percent float64 `json:"percent"` // fee in percent of the converted amount
+	Minimum float64 `json:"minimum"` // minimum fee in the target currency
+}
+
+// a named set of fees, the embedded rule applies globally unless the pair
+// ("FROM/TO", eg. "EUR/DKK") has its own rule
+type feeProfile struct {
+	feeRule
+	Pairs map[string]feeRule `json:"pairs"`
+}
+
+// struct for a single converted amount with the fee broken out
+type convertedAmount struct {
+	Amount      float64 `json:"amount" xml:"amount,attr"`
+	MidRate     float64 `json:"mid_rate" xml:"mid_rate,attr"`
+	AppliedRate float64 `json:"applied_rate" xml:"applied_rate,attr"`
+	Converted   float64 `json:"converted" xml:"converted,attr"`
+	Fee         float64 `json:"fee" xml:"fee,attr"`
+	Total       float64 `json:"total" xml:"total,attr"`
+
+	FormattedAmount    string `json:"formatted_amount,omitempty" xml:"formatted_amount,attr,omitempty"`
+	FormattedConverted string `json:"formatted_converted,omitempty" xml:"formatted_converted,attr,omitempty"`
+	FormattedFee       string `json:"formatted_fee,omitempty" xml:"formatted_fee,attr,omitempty"`
+	FormattedTotal     string `json:"formatted_total,omitempty" xml:"formatted_total,attr,omitempty"`
+}
+
+// Loads the fee profiles from the JSON file at the given path. An empty path
+// means no profiles.
+func loadFeeProfiles(path string) (profiles map[string]feeProfile, err error) {
+	profiles = make(map[string]feeProfile)
+	if path == "" {
+		return profiles, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &profiles)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing fee profiles: %s", err)
+	}
+
+	for name, p := range profiles {
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("Invalid fee profile %s: %s", name, err)
+		}
+
+		for pair, rule := range p.Pairs {
+			if err := rule.validate(); err != nil {
+				return nil, fmt.Errorf("Invalid fee profile %s (%s): %s", name, pair, err)
+			}
+		}
+	}
+
+	return profiles, nil
+}
+
+// returns an error if the rule can't be applied
+func (f feeRule) validate() error {
+	if f.Spread <= -100 {
+		return fmt.Errorf("Spread must be above -100: %f", f.Spread)
+	}
+
+	if f.Percent < 0 || f.Minimum < 0 {
+		return fmt.Errorf("Fees can't be negative")
+	}
+
+	return nil
+}
+
+// returns the rule for converting from one currency to another
+func (p feeProfile) rule(to, from string) feeRule {
+	if rule, found := p.Pairs[from+"/"+to]; found {
+		return rule
+	}
+
+	return p.feeRule
+}
+
+// Applies the given fee profile to a conversion response. The rate of the
+// response is the mid rate, the converted amounts are replaced by the
+// amounts at the applied rate and the details for every amount are added.
+func (s *Server) applyFees(response *convertResponse, profile string, amounts []float64) (err error) {
+	p, found := s.feeProfiles[profile]
+	if !found {
+		return fmt.Errorf("Unknown fee profile: %s", profile)
+	}
+
+	midRate := response.Rate
+	rule := p.rule(response.TargetCurrency, response.BaseCurrency)
+	appliedRate := midRate * (1 + rule.Spread/100)
+
+	response.FeeProfile = profile
+	response.ConvertedAmounts = nil
+	for _, amount := range amounts {
+		d := convertedAmount{
+			Amount:      amount,
+			MidRate:     midRate,
+			AppliedRate: appliedRate,
+			Converted:   amount * appliedRate,
+		}
+
+		// nothing converted, nothing to charge. The fee of a negative
+		// amount (a refund) is negative too, the minimum applies to its size
+		if amount != 0 {
+			d.Fee = math.Copysign(math.Max(math.Abs(d.Converted)*rule.Percent/100, rule.Minimum), amount)
+		}
+
+		d.Total = d.Converted + d.Fee
+
+		response.ConvertedAmounts = append(response.ConvertedAmounts, d.Converted)
+		response.Details = append(response.Details, d)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	checkoutProfile = feeProfile{
+		feeRule: feeRule{Spread: 2, Percent: 1, Minimum: 0.5},
+		Pairs: map[string]feeRule{
+			"EUR/DKK": {Spread: 0.5},
+		},
+	}
+)
+
+func TestConvertWithFees(t *testing.T) {
+	server.feeProfiles["checkout"] = checkoutProfile
+	amounts := []float64{0, 10, 1000, -10, -1000}
+	res, err := server.createConvertResponse("USD", eur, amounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.applyFees(res, "checkout", amounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mid := server.currencies["USD"]
+	applied := mid * 1.02
+	for i, d := range res.Details {
+		if d.MidRate != mid || d.AppliedRate != applied {
+			t.Fatal("Unexpected rates:", d.MidRate, d.AppliedRate)
+		}
+
+		if d.Converted != amounts[i]*applied || res.ConvertedAmounts[i] != d.Converted {
+			t.Fatal("Unexpected converted amount:", d.Converted)
+		}
+
+		if d.Total != d.Converted+d.Fee {
+			t.Fatal("Unexpected total:", d.Total)
+		}
+	}
+
+	if res.Details[0].Fee != 0 {
+		t.Fatal("Expected no fee for nothing:", res.Details[0].Fee)
+	}
+
+	if res.Details[1].Fee != 0.5 {
+		t.Fatal("Expected the minimum fee:", res.Details[1].Fee)
+	}
+
+	if fmt.Sprintf("%.4f", res.Details[2].Fee) != fmt.Sprintf("%.4f", 1000*applied/100) {
+		t.Fatal("Expected the percentage fee:", res.Details[2].Fee)
+	}
+
+	// refunds mirror the fees of the amounts
+	if res.Details[3].Fee != -res.Details[1].Fee || res.Details[4].Fee != -res.Details[2].Fee {
+		t.Fatal("Expected negative fees for refunds:", res.Details[3].Fee, res.Details[4].Fee)
+	}
+}
+
+func TestConvertWithPairFees(t *testing.T) {
+	server.feeProfiles["checkout"] = checkoutProfile
+	r := fireReq("/convert", http.MethodPost, convertRequest{
+		BaseCurrency:   eur,
+		TargetCurrency: "DKK",
+		Amounts:        []float64{100},
+		FeeProfile:     "checkout",
+	})
+	var res convertResponse
+	expect(t, r, http.StatusOK, true, &res)
+	if res.FeeProfile != "checkout" || len(res.Details) != 1 {
+		t.Fatal("Expected fee details:", res)
+	}
+
+	if res.Details[0].AppliedRate != res.Details[0].MidRate*1.005 || res.Details[0].Fee != 0 {
+		t.Fatal("Expected the pair rule:", res.Details[0])
+	}
+}
+
+func TestConvertUnknownFeeProfile(t *testing.T) {
+	r := fireReq("/convert", http.MethodPost, convertRequest{
+		BaseCurrency:   eur,
+		TargetCurrency: "DKK",
+		Amounts:        []float64{100},
+		FeeProfile:     "unknown",
+	})
+	expect(t, r, http.StatusInternalServerError, true, nil)
+}
+
+func TestLoadFeeProfiles(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.json")
+	os.WriteFile(valid, []byte(`{"retail":{"spread":1.5,"percent":0.5,"minimum":1,"pairs":{"EUR/USD":{"spread":1}}}}`), 0600)
+	profiles, err := loadFeeProfiles(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if profiles["retail"].Spread != 1.5 || profiles["retail"].rule("USD", eur).Spread != 1 {
+		t.Fatal("Unexpected profiles:", profiles)
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte(`{"retail":{"pairs":{"EUR/USD":{"minimum":-1}}}}`), 0600)
+	if _, err := loadFeeProfiles(invalid); err == nil {
+		t.Fatal("Expected error on negative fee")
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultMinorUnits = 2        // minor units of currencies not in the catalogue
+	nbsp              = "\u00a0" // no-break space
+	nnbsp             = "\u202f" // narrow no-break space
+)
+
+// The number format of a locale, derived from the CLDR data. The symbols
+// and minor units come from the catalogue, a locale only lists the symbols
+// it writes differently. Currencies not in the catalogue are shown as the
+// ISO code.
+type numberFormat struct {
+	group   string            // grouping separator
+	decimal string            // decimal separator
+	prefix  bool              // true if the symbol goes before the amount
+	spacing string            // between the symbol and the amount
+	symbols map[string]string // symbols specific to the locale
+}
+
+// the known number formats by locale, lower case
+var numberFormats = map[string]numberFormat{
+	"en":    {group: ",", decimal: ".", prefix: true},
+	"en-gb": {group: ",", decimal: ".", prefix: true, symbols: map[string]string{"USD": "US$"}},
+	"da":    {group: ".", decimal: ",", spacing: nbsp, symbols: map[string]string{"USD": "US$"}},
+	"de":    {group: ".", decimal: ",", spacing: nbsp},
+	"de-ch": {group: "’", decimal: ".", prefix: true, spacing: nbsp},
+	"fr":    {group: nnbsp, decimal: ",", spacing: nbsp, symbols: map[string]string{"USD": "$US"}},
+	"sv":    {group: nbsp, decimal: ",", spacing: nbsp, symbols: map[string]string{"USD": "US$"}},
+	"nb":    {group: nbsp, decimal: ",", spacing: nbsp, symbols: map[string]string{"USD": "USD"}},
+	"ja":    {group: ",", decimal: ".", prefix: true, symbols: map[string]string{"JPY": "￥"}},
+}
+
+// Returns the number format of the locale, first the full locale ("de-CH")
+// then the language ("de") and english if neither is known.
+func localeFormat(locale string) numberFormat {
+	full, lang := splitLocale(locale)
+	if f, found := numberFormats[full]; found {
+		return f
+	}
+
+	if f, found := numberFormats[lang]; found {
+		return f
+	}
+
+	return numberFormats["en"]
+}
+
+// returns the symbol of the currency in the number format
+func (f numberFormat) symbol(currency string) string {
+	if symbol, found := f.symbols[currency]; found {
+		return symbol
+	}
+
+	if info, found := catalogue[currency]; found {
+		return info.Symbol
+	}
+
+	return currency
+}
+
+// Formats an amount of the currency for the locale, rounded to the minor
+// units of the currency, eg. "$1,234.56" or "1.234,56 kr.".
+func formatAmount(amount float64, currency, locale string) string {
+	f := localeFormat(locale)
+
+	minorUnits := defaultMinorUnits
+	if info, found := catalogue[currency]; found {
+		minorUnits = info.MinorUnits
+	}
+
+	// round half away from zero like a cashier rather than to even
+	scale := math.Pow10(minorUnits)
+	digits := strconv.FormatFloat(math.Round(math.Abs(amount)*scale)/scale, 'f', minorUnits, 64)
+	integer, fraction := digits, ""
+	if i := strings.Index(digits, "."); i >= 0 {
+		integer, fraction = digits[:i], digits[i+1:]
+	}
+
+	// group the integer part by thousands
+	var groups []string
+	for len(integer) > 3 {
+		groups = append([]string{integer[len(integer)-3:]}, groups...)
+		integer = integer[:len(integer)-3]
+	}
+	groups = append([]string{integer}, groups...)
+
+	number := strings.Join(groups, f.group)
+	if fraction != "" {
+		number += f.decimal + fraction
+	}
+
+	var formatted string
+	if f.prefix {
+		formatted = f.symbol(currency) + f.spacing + number
+	} else {
+		formatted = number + f.spacing + f.symbol(currency)
+	}
+
+	// no negative zero after rounding
+	if amount < 0 && strings.Trim(digits, "0.") != "" {
+		formatted = "-" + formatted
+	}
+
+	return formatted
+}
+
+// Adds the amounts formatted for the locale to a conversion response.
+func addFormattedAmounts(response *convertResponse, locale string) {
+	to := response.TargetCurrency
+	response.Locale = locale
+	response.FormattedAmounts = nil
+	for _, amount := range response.ConvertedAmounts {
+		response.FormattedAmounts = append(response.FormattedAmounts, formatAmount(amount, to, locale))
+	}
+
+	for i, d := range response.Details {
+		response.Details[i].FormattedAmount = formatAmount(d.Amount, response.BaseCurrency, locale)
+		response.Details[i].FormattedConverted = formatAmount(d.Converted, to, locale)
+		response.Details[i].FormattedFee = formatAmount(d.Fee, to, locale)
+		response.Details[i].FormattedTotal = formatAmount(d.Total, to, locale)
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFormatAmount(t *testing.T) {
+	cases := []struct {
+		amount   float64
+		currency string
+		locale   string
+		expected string
+	}{
+		{1234.56, "USD", "en", "$1,234.56"},
+		{1234.56, "DKK", "da-DK", "1.234,56 kr."},
+		{1234.56, "EUR", "de", "1.234,56 €"},
+		{1234.56, "CHF", "de_CH", "CHF 1’234.56"},
+		{1234567.891, "EUR", "fr", "1 234 567,89 €"},
+		{1234.5, "JPY", "ja", "￥1,235"},
+		{1234.5, "JPY", "en", "¥1,235"},
+		{-5, "GBP", "en-GB", "-£5.00"},
+		{-0.001, "USD", "en", "$0.00"},
+		{12, "DKK", "en", "kr.12.00"},
+		{12, "SEK", "sv", "12,00 kr"},
+		{12, "AED", "en", "AED12.00"},
+		{12, "USD", "da", "12,00 US$"},
+		{999.999, "POINTS", "xx", "POINTS1,000.00"},
+	}
+
+	for _, c := range cases {
+		formatted := strings.NewReplacer(nbsp, " ", nnbsp, " ").Replace(formatAmount(c.amount, c.currency, c.locale))
+		if formatted != c.expected {
+			t.Fatal("Unexpected format:", c.locale, c.currency, formatted, c.expected)
+		}
+	}
+}
+
+func TestFormatCatalogueSymbols(t *testing.T) {
+	for code, info := range catalogue {
+		if formatted := formatAmount(1, code, "en"); !strings.HasPrefix(formatted, info.Symbol) {
+			t.Fatal("Expected the symbol of the catalogue:", code, formatted, info.Symbol)
+		}
+	}
+}
+
+func TestConvertFormatted(t *testing.T) {
+	server.feeProfiles["checkout"] = checkoutProfile
+	r := fireReq("/convert", http.MethodPost, convertRequest{
+		BaseCurrency:   "USD",
+		TargetCurrency: "DKK",
+		Amounts:        []float64{1000},
+		FeeProfile:     "checkout",
+		Locale:         "da-DK",
+	})
+	var res convertResponse
+	expect(t, r, http.StatusOK, true, &res)
+	if res.Locale != "da-DK" || len(res.FormattedAmounts) != 1 {
+		t.Fatal("Expected formatted amounts:", res)
+	}
+
+	if res.FormattedAmounts[0] != formatAmount(res.ConvertedAmounts[0], "DKK", "da") {
+		t.Fatal("Unexpected formatted amount:", res.FormattedAmounts[0])
+	}
+
+	d := res.Details[0]
+	if d.FormattedAmount != "1.000,00"+nbsp+"US$" || !strings.HasSuffix(d.FormattedTotal, nbsp+"kr.") {
+		t.Fatal("Unexpected formatted details:", d)
+	}
+}
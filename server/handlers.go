@@ -1,28 +1,37 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
-	"log"
+	"expvar"
+	"fmt"
 	"net/http"
-	"strings"
+	"time"
 )
 
 // struct for the currency rates
 type currencyResponse struct {
-	CurrencyDate string         `json:"currency_date"`
-	BaseCurrency string         `json:"base_currency"`
-	Rates        []rateResponse `json:"rates"`
+	CurrencyDate string         `json:"currency_date" xml:"time,attr"`
+	BaseCurrency string         `json:"base_currency" xml:"base,attr"`
+	RateVersion  string         `json:"rate_version" xml:"version,attr"`
+	Provider     string         `json:"provider" xml:"provider,attr"`
+	Rates        []rateResponse `json:"rates" xml:"Cube"`
 }
 
 // struct for the single rates
 type rateResponse struct {
-	Name string  `json:"name"`
-	Rate float64 `json:"rate"`
+	Name       string         `json:"name" xml:"currency,attr"`
+	Rate       float64        `json:"rate" xml:"rate,attr"`
+	Source     string         `json:"source" xml:"source,attr"`
+	Mismatches []rateMismatch `json:"mismatches,omitempty" xml:"Mismatch"`
+	Currency   *currencyInfo  `json:"currency,omitempty" xml:"Currency"`
 }
 
 // struct for the currency request with a different base
 type currencyRequest struct {
 	BaseCurrency string `json:"base_currency"`
+	Details      bool   `json:"details"`
+	Locale       string `json:"locale"`
 }
 
 // struct for the currency convertion request
@@ -30,65 +39,168 @@ type convertRequest struct {
 	BaseCurrency   string    `json:"base_currency"`
 	TargetCurrency string    `json:"target_currency"`
 	Amounts        []float64 `json:"amounts"`
+	FeeProfile     string    `json:"fee_profile"`
+	RateType       string    `json:"rate_type"`
+	Period         string    `json:"period"`
+	Locale         string    `json:"locale"`
 }
 
 // struct for the currency convertion response
 type convertResponse struct {
-	BaseCurrency     string    `json:"base_currency"`
-	TargetCurrency   string    `json:"target_currency"`
-	CurrencyDate     string    `json:"currency_date"`
-	ConvertedAmounts []float64 `json:"converted_amounts"`
+	BaseCurrency     string    `json:"base_currency" xml:"base,attr"`
+	TargetCurrency   string    `json:"target_currency" xml:"target,attr"`
+	CurrencyDate     string    `json:"currency_date" xml:"time,attr"`
+	RateVersion      string    `json:"rate_version" xml:"version,attr"`
+	Rate             float64   `json:"rate" xml:"rate,attr"`
+	InverseRate      float64   `json:"inverse_rate" xml:"inverse_rate,attr"`
+	RateType         string    `json:"rate_type,omitempty" xml:"rate_type,attr,omitempty"`
+	Period           string    `json:"period,omitempty" xml:"period,attr,omitempty"`
+	Provider         string    `json:"provider" xml:"provider,attr"`
+	Mismatches       []string  `json:"mismatches,omitempty" xml:"Mismatch"`
+	Overrides        []string  `json:"overrides,omitempty" xml:"Override"`
+	FeeProfile       string    `json:"fee_profile,omitempty" xml:"fee_profile,attr,omitempty"`
+	Locale           string    `json:"locale,omitempty" xml:"locale,attr,omitempty"`
+	ConvertedAmounts []float64 `json:"converted_amounts" xml:"Converted"`
+	FormattedAmounts []string  `json:"formatted_amounts,omitempty" xml:"Formatted"`
+
+	Details []convertedAmount `json:"details,omitempty" xml:"Detail"`
 }
 
 // The main serving function. This handles all requests to he server by
 // delegating the requests to the other handlers.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	log.Printf("[%s] %s\n", r.Method, r.RequestURI)
+	// tag the request with the ID of the client or a new one
+	id := requestId(r)
+	r = s.withRequestId(r, id)
+	w.Header().Set(requestIdHeader, id)
+
+	// continue the trace of the caller, the span covers the whole request
+	route := routeLabel(r.URL.Path)
+	ctx, span := startSpan(s.tracer.context(r.Context(), r.Header), r.Method+" "+route, spanServer)
+	r = r.WithContext(ctx)
+	span.set("http.method", r.Method)
+	span.set("http.route", route)
+	span.set("http.request_id", id)
+
+	// record the status, size and latency of the response once it's sent
+	start, sw := time.Now(), &statusWriter{ResponseWriter: w}
+	defer func() {
+		accessLog(r, sw, time.Since(start))
+		s.metrics.observeRequest(route, sw.code(), time.Since(start))
+
+		var err error
+		if sw.code() >= http.StatusInternalServerError {
+			err = fmt.Errorf("Status %d", sw.code())
+		}
+		span.set("http.status_code", sw.code())
+		span.end(err)
+	}()
+
+	// compress the response if the client supports it
+	w = newCompressWriter(sw, r)
+	if cw, ok := w.(*compressWriter); ok {
+		defer cw.Close()
+	}
+
+	// set the CORS headers, answer preflights
+	if s.handleCors(w, r) {
+		return
+	}
+
+	// check the API key and its rate limit
+	if !s.authorizeKey(w, r) {
+		return
+	}
+
+	// the monitoring and admin endpoints work without currencies, eg. to
+	// set overrides while the provider is down. The metrics and the expvars
+	// (including the command line) are only served to the admin.
+	switch r.URL.Path {
+	case "/metrics":
+		if s.authorizeAdmin(w, r) {
+			s.metricsHandler(w, r)
+		}
+		return
+	case "/debug/vars":
+		if s.authorizeAdmin(w, r) {
+			expvar.Handler().ServeHTTP(w, r)
+		}
+		return
+	case "/healthz", "/readyz":
+		s.healthHandler(w, r)
+		return
+	case "/admin/overrides":
+		s.overridesHandler(w, r)
+		return
+	case "/admin/keys":
+		s.keysHandler(w, r)
+		return
+	case "/admin/webhooks":
+		s.webhooksHandler(w, r)
+		return
+	}
 
 	// error if there is no currencies
-	if !s.hasCurrencies {
-		log.Println("No currencies, returning error!")
+	if !s.ratesLoaded() {
+		requestLogger(r).Warn("No currencies, returning error")
 		http.Error(w, "No currencies", http.StatusServiceUnavailable)
 		return
 	}
 
-	// select the correct handler, error on unknown URI
-	switch r.RequestURI {
+	// select the correct handler, error on unknown path
+	switch r.URL.Path {
 	case "/currencies":
 		s.currenciesHandler(w, r)
 	case "/convert":
 		s.convertHandler(w, r)
 	case "/webhook":
 		s.webhookHandler(w, r)
+	case "/script":
+		s.scriptHandler(w, r)
+	case "/script.d.ts":
+		s.typescriptHandler(w, r)
+	case "/aggregate":
+		s.aggregateHandler(w, r)
+	case "/timeseries":
+		s.seriesHandler(w, r)
+	case "/chart.svg":
+		s.chartHandler(w, r)
+	case "/catalogue":
+		s.catalogueHandler(w, r)
 	default:
-		if strings.HasPrefix(r.RequestURI, "/script?base=") {
-			s.scriptHandler(w, r)
-		} else {
-			http.NotFound(w, r)
-		}
+		http.NotFound(w, r)
 	}
 }
 
 // Handles currency requests (/currencies)
 func (s *Server) currenciesHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		// GET - create response with EUR base
-		res, err := s.createResponse(eur)
-		s.respondJson(w, res, err)
+		// GET - create response with the default base, add details if requested
+		details, locale := r.URL.Query().Get("details") == "true", r.URL.Query().Get("locale")
+		res, err := s.createResponse(s.defaultBase)
+		if err == nil && details {
+			s.addCurrencyDetails(res, locale)
+		}
+
+		s.respondNegotiated(w, r, currencyKey(s.defaultBase, details, locale), "Reference rates", res, err)
 		s.currencyHits.Add(1)
 	} else if r.Method == http.MethodPost {
 		// POST - parse request to get base, fail on error
 		var req currencyRequest
 		err := s.getJsonRequest(r, &req)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("Error parsing request", "error", err)
 			http.Error(w, "", http.StatusInternalServerError)
 			return
 		}
 
-		// create reponse with parsed base
+		// create reponse with parsed base, add details if requested
 		res, err := s.createResponse(req.BaseCurrency)
-		s.respondJson(w, res, err)
+		if err == nil && req.Details {
+			s.addCurrencyDetails(res, req.Locale)
+		}
+
+		s.respondNegotiated(w, r, currencyKey(req.BaseCurrency, req.Details, req.Locale), "Reference rates", res, err)
 		s.currencyHits.Add(1)
 	} else {
 		http.Error(w, "", http.StatusBadRequest)
@@ -103,14 +215,31 @@ func (s *Server) convertHandler(w http.ResponseWriter, r *http.Request) {
 		var req convertRequest
 		err := s.getJsonRequest(r, &req)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("Error parsing request", "error", err)
 			http.Error(w, "", http.StatusInternalServerError)
 			return
 		}
 
-		// create the convertion response
-		res, err := s.createConvertResponse(req.TargetCurrency, req.BaseCurrency, req.Amounts)
-		s.respondJson(w, res, err)
+		// create the convertion response, from the history if a rate
+		// type is requested
+		var res *convertResponse
+		if req.RateType != "" {
+			res, err = s.createPeriodConvertResponse(req.TargetCurrency, req.BaseCurrency, req.Amounts, req.RateType, req.Period)
+		} else {
+			res, err = s.createConvertResponse(req.TargetCurrency, req.BaseCurrency, req.Amounts)
+		}
+
+		// add the fees if a profile is requested
+		if err == nil && req.FeeProfile != "" {
+			err = s.applyFees(res, req.FeeProfile, req.Amounts)
+		}
+
+		// add the formatted amounts if a locale is requested
+		if err == nil && req.Locale != "" {
+			addFormattedAmounts(res, req.Locale)
+		}
+
+		s.respondNegotiated(w, r, requestKey(req), "Conversion", res, err)
 		s.convertHits.Add(1)
 	} else {
 		http.Error(w, "", http.StatusBadRequest)
@@ -125,22 +254,33 @@ func (s *Server) webhookHandler(w http.ResponseWriter, r *http.Request) {
 		var hook webhook
 		err := s.getJsonRequest(r, &hook)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("Error parsing request", "error", err)
 			http.Error(w, "", http.StatusInternalServerError)
 			return
 		}
 
-		// verify the webhook data and insert
-		if s.verifyWebhook(hook) {
-			s.mutex.Lock()
-			defer s.mutex.Unlock()
-
-			s.webhooks[hook.Url] = hook
-			s.webhookHits.Add(1)
-		} else {
+		// verify the webhook data and insert, URLs rejected by the policy
+		// are bad requests
+		err = s.verifyWebhook(&hook)
+		if rejectedWebhook(err) {
+			requestLogger(r).Warn("Webhook rejected", "url", hook.Url, "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			requestLogger(r).Error("Error verifying webhook", "url", hook.Url, "error", err)
 			http.Error(w, "", http.StatusInternalServerError)
 			return
 		}
+
+		// register and challenge the webhook, accepted if it is pending
+		hook = s.registerWebhook(r.Context(), hook)
+		hook.Secret = ""
+		code := http.StatusOK
+		if hook.Status == webhookPending {
+			code = http.StatusAccepted
+		}
+
+		s.respondJsonStatus(w, code, hook, nil)
 	} else {
 		http.NotFound(w, r)
 	}
@@ -149,24 +289,34 @@ func (s *Server) webhookHandler(w http.ResponseWriter, r *http.Request) {
 // generic method to return JSON of v to a http.ResponseWriter, return proper
 // status code if the passed error is not nil
 func (s *Server) respondJson(w http.ResponseWriter, v interface{}, err error) {
-	// add header for content type
-	w.Header().Add("Content-Type", "application/json")
+	s.respondJsonStatus(w, http.StatusOK, v, err)
+}
 
+// Returns JSON of v with the status code. The body is encoded before the
+// headers are written, so an encoding error still results in an error.
+func (s *Server) respondJsonStatus(w http.ResponseWriter, code int, v interface{}, err error) {
 	// return internal server error if err is not nil
 	if err != nil {
-		log.Println(err)
+		s.logger.Error("Error creating response", "error", err)
 		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
 
 	// encode as JSON
-	err = json.NewEncoder(w).Encode(v)
+	buff := &bytes.Buffer{}
+	err = json.NewEncoder(buff).Encode(v)
 
 	// return error if encoding caused one
 	if err != nil {
-		log.Println(err)
+		s.logger.Error("Error encoding response", "error", err)
 		http.Error(w, "Error creating JSON response.", http.StatusInternalServerError)
+		return
 	}
+
+	// set the headers before the status is written
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(buff.Bytes())
 }
 
 // parses the given http.Request into the given interface
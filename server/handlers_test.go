@@ -75,14 +75,21 @@ func TestNotFoundRoute(t *testing.T) {
 }
 
 func fireReq(endpoint, method string, data interface{}) (rec *httptest.ResponseRecorder) {
+	return fireReqHeaders(endpoint, method, data, nil)
+}
+
+func fireReqHeaders(endpoint, method string, data interface{}, headers map[string]string) (rec *httptest.ResponseRecorder) {
 	rec = httptest.NewRecorder()
 	buff := &bytes.Buffer{}
 	if data != nil {
 		bytes, _ := json.Marshal(&data)
 		buff.Write(bytes)
 	}
-	req, _ := http.NewRequest(method, "", buff)
+	req, _ := http.NewRequest(method, endpoint, buff)
 	req.RequestURI = endpoint
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
 	server.ServeHTTP(rec, req)
 
 	return rec
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	defaultReadyMaxAge = "3h" // default time rates may be overdue before the server is not ready
+
+	healthOk      = "ok"       // rates are loaded and fresh
+	healthNoRates = "no_rates" // no rates have been fetched yet
+	healthStale   = "stale"    // newer rates should have been published
+)
+
+// struct for the health and readiness responses
+type healthResponse struct {
+	Status          string     `json:"status"`
+	RateDate        string     `json:"rate_date,omitempty"`
+	RateAge         int        `json:"rate_age_seconds"`
+	Overdue         int        `json:"overdue_seconds"`
+	NextPublication *time.Time `json:"next_publication,omitempty"`
+	LastFetch       *time.Time `json:"last_fetch,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+	LastErrorTime   *time.Time `json:"last_error_time,omitempty"`
+	Provider        string     `json:"provider,omitempty"`
+}
+
+// records a failed fetch for the health responses
+func (s *Server) fetchFailed(err error) {
+	s.rateMutex.Lock()
+	defer s.rateMutex.Unlock()
+
+	s.lastFetchError, s.lastErrorTime = err.Error(), time.Now()
+}
+
+// returns a pointer to the UTC time, nil for the zero time
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	t = t.UTC()
+	return &t
+}
+
+// Returns the health of the rates at the given time. The rates are stale
+// once the next publication after their date is overdue by more than the
+// max age, so weekends and holidays without new rates never count.
+func (s *Server) health(now time.Time) healthResponse {
+	s.rateMutex.RLock()
+	defer s.rateMutex.RUnlock()
+
+	res := healthResponse{
+		Status:        healthNoRates,
+		LastFetch:     optionalTime(s.lastFetch),
+		LastError:     s.lastFetchError,
+		LastErrorTime: optionalTime(s.lastErrorTime),
+	}
+
+	if !s.hasCurrencies {
+		return res
+	}
+
+	published := publicationTime(s.lastUpdateTime)
+	next := nextPublication(published)
+	res.RateDate = s.lastUpdateTime.Format(currencyDateFormat)
+	res.Provider = s.provider
+	res.NextPublication = optionalTime(next)
+
+	if age := now.Sub(published); age > 0 {
+		res.RateAge = int(age.Seconds())
+	}
+
+	res.Status = healthOk
+	if overdue := now.Sub(next); overdue > 0 {
+		res.Overdue = int(overdue.Seconds())
+		if overdue > s.readyMaxAge {
+			res.Status = healthStale
+		}
+	}
+
+	return res
+}
+
+// Handles the liveness (/healthz) and readiness (/readyz) probes. Both
+// return the health of the rates, liveness always with 200 and readiness
+// with 503 unless the rates are loaded and fresh.
+func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	res := s.health(time.Now())
+	w.Header().Set("Cache-Control", "no-store")
+	code := http.StatusOK
+	if r.URL.Path == "/readyz" && res.Status != healthOk {
+		code = http.StatusServiceUnavailable
+	}
+
+	s.respondJsonStatus(w, code, res, nil)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	s := &Server{rateMutex: &sync.RWMutex{}, readyMaxAge: 3 * time.Hour}
+	if res := s.health(time.Now()); res.Status != healthNoRates || res.RateDate != "" {
+		t.Fatal("Unexpected health without rates:", res)
+	}
+
+	s.fetchFailed(errors.New("provider down"))
+	s.hasCurrencies, s.provider = true, "ecb"
+	s.lastUpdateTime, _ = time.Parse(currencyDateFormat, "2026-10-16")
+
+	// friday's rates are fresh over the weekend until monday's are overdue
+	for now, status := range map[string]string{
+		"2026-10-18T12:00:00+02:00": healthOk,
+		"2026-10-19T18:00:00+02:00": healthOk,
+		"2026-10-19T19:30:00+02:00": healthStale,
+	} {
+		at, _ := time.Parse(time.RFC3339, now)
+		res := s.health(at)
+		if res.Status != status || res.RateDate != "2026-10-16" || res.Provider != "ecb" || res.LastError != "provider down" {
+			t.Fatal("Unexpected health:", now, res)
+		}
+	}
+
+	// thursday's rates are stale on friday evening
+	s.lastUpdateTime, _ = time.Parse(currencyDateFormat, "2026-10-15")
+	at, _ := time.Parse(time.RFC3339, "2026-10-16T20:00:00+02:00")
+	if res := s.health(at); res.Status != healthStale || res.Overdue != 4*60*60 {
+		t.Fatal("Unexpected health:", res)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	defer func(d time.Duration) { server.readyMaxAge = d }(server.readyMaxAge)
+	server.readyMaxAge = 24 * 365 * time.Hour
+
+	var res healthResponse
+	r := fireReq("/readyz", http.MethodGet, nil)
+	expect(t, r, http.StatusOK, true, &res)
+	if res.Status != healthOk || res.RateDate == "" || res.NextPublication == nil {
+		t.Fatal("Unexpected readiness:", res)
+	}
+
+	server.readyMaxAge = 0
+	server.rateMutex.Lock()
+	lastUpdate := server.lastUpdateTime
+	server.lastUpdateTime = lastUpdate.AddDate(-1, 0, 0)
+	server.rateMutex.Unlock()
+	defer func() { server.rateMutex.Lock(); server.lastUpdateTime = lastUpdate; server.rateMutex.Unlock() }()
+
+	r = fireReq("/readyz", http.MethodGet, nil)
+	expect(t, r, http.StatusServiceUnavailable, true, &res)
+	if res.Status != healthStale {
+		t.Fatal("Unexpected readiness:", res)
+	}
+
+	// liveness succeeds with stale rates
+	r = fireReq("/healthz", http.MethodGet, nil)
+	expect(t, r, http.StatusOK, true, &res)
+	if res.Status != healthStale {
+		t.Fatal("Unexpected health:", res)
+	}
+}
+
+func TestHealthDuringWebhooks(t *testing.T) {
+	called, release := make(chan bool, 1), make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called <- true
+		<-release
+	}))
+	defer ts.Close()
+
+	server.mutex.Lock()
+	server.webhooks[ts.URL] = webhook{BaseCurrency: eur, Url: ts.URL, Status: webhookActive, registered: time.Now()}
+	server.mutex.Unlock()
+	defer func() {
+		server.mutex.Lock()
+		delete(server.webhooks, ts.URL)
+		server.mutex.Unlock()
+	}()
+
+	done := make(chan bool)
+	go func() {
+		server.callWebhooks(context.Background())
+		done <- true
+	}()
+
+	select {
+	case <-called:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the webhook to be called")
+	}
+
+	// the probes, the metrics and the webhook list answer while a webhook
+	// hangs
+	answered := make(chan bool)
+	go func() {
+		fireReq("/healthz", http.MethodGet, nil)
+		fireReqHeaders("/metrics", http.MethodGet, nil, adminHeaders)
+		fireReqHeaders("/admin/webhooks", http.MethodGet, nil, adminHeaders)
+		answered <- true
+	}()
+
+	select {
+	case <-answered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the probes to answer during the webhook calls")
+	}
+
+	close(release)
+	<-done
+}
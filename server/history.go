@@ -0,0 +1,409 @@
+package server
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	ecbHistoryUrl = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist-90d.xml"
+
+	rateTypeAverage = "average" // the average rate over a period
+	rateTypeClosing = "closing" // the last rate in a period
+)
+
+// The daily rates, all relative to EUR like the provider data. The history
+// has its own lock so queries aren't blocked by the webhook calls.
+type rateHistory struct {
+	mutex *sync.RWMutex
+	days  map[string]map[string]float64 // rates by date (2006-01-02)
+}
+
+// a single day in the history
+type historyDay struct {
+	date  time.Time
+	rates map[string]float64
+}
+
+// struct for the aggregated rates of a currency pair over a period
+type aggregateResponse struct {
+	BaseCurrency   string  `json:"base_currency"`
+	TargetCurrency string  `json:"target_currency"`
+	Start          string  `json:"start"`
+	End            string  `json:"end"`
+	Days           int     `json:"days"`
+	Average        float64 `json:"average"`
+	Min            float64 `json:"min"`
+	Max            float64 `json:"max"`
+	Open           float64 `json:"open"`
+	OpenDate       string  `json:"open_date"`
+	Close          float64 `json:"close"`
+	CloseDate      string  `json:"close_date"`
+	MissingDays    int     `json:"missing_days"`
+	Finished       bool    `json:"finished"`
+
+	sum   float64            // sum of the rates for the average
+	rates map[string]float64 // the rate of every day by date
+}
+
+// The ECB history XML data
+type historyEnvelope struct {
+	Days []historyCube `xml:"Cube>Cube"`
+}
+
+// The cube XML structure for a single day
+type historyCube struct {
+	Time  string `xml:"time,attr"`
+	Rates []cube `xml:"Cube"`
+}
+
+// creates an empty history
+func newRateHistory() *rateHistory {
+	return &rateHistory{
+		mutex: &sync.RWMutex{},
+		days:  make(map[string]map[string]float64),
+	}
+}
+
+// adds the rates of a single day, replacing any rates already stored
+func (h *rateHistory) add(ts time.Time, rates map[string]float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.days[ts.Format(currencyDateFormat)] = rates
+}
+
+// returns the number of stored days
+func (h *rateHistory) len() int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return len(h.days)
+}
+
+// returns the oldest stored day, false if the history is empty
+func (h *rateHistory) first() (first time.Time, found bool) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	oldest := ""
+	for date := range h.days {
+		if oldest == "" || date < oldest {
+			oldest = date
+		}
+	}
+
+	first, err := time.Parse(currencyDateFormat, oldest)
+	return first, err == nil
+}
+
+// returns the days within start and end (both inclusive) ordered by date
+func (h *rateHistory) between(start, end time.Time) (days []historyDay) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	first, last := start.Format(currencyDateFormat), end.Format(currencyDateFormat)
+	for date, rates := range h.days {
+		if date < first || date > last {
+			continue
+		}
+
+		ts, _ := time.Parse(currencyDateFormat, date)
+		days = append(days, historyDay{date: ts, rates: rates})
+	}
+
+	sort.Slice(days, func(i, j int) bool { return days[i].date.Before(days[j].date) })
+	return days
+}
+
+// creates an empty aggregate of the currency pair
+func newAggregate(to, from string) *aggregateResponse {
+	return &aggregateResponse{BaseCurrency: from, TargetCurrency: to, rates: make(map[string]float64)}
+}
+
+// Adds the rate of a currency pair for a single day to the aggregate. Days
+// where either currency is unknown are skipped. Days must be added in order.
+func (a *aggregateResponse) add(day historyDay) {
+	rate, err := crossRate(day.rates, a.TargetCurrency, a.BaseCurrency)
+	if err != nil {
+		return
+	}
+
+	date := day.date.Format(currencyDateFormat)
+	if a.Days == 0 {
+		a.Open, a.OpenDate = rate, date
+		a.Min, a.Max = rate, rate
+	}
+
+	if rate < a.Min {
+		a.Min = rate
+	}
+
+	if rate > a.Max {
+		a.Max = rate
+	}
+
+	a.Close, a.CloseDate = rate, date
+	a.Days++
+	a.sum += rate
+	a.Average = a.sum / float64(a.Days)
+	a.rates[date] = rate
+}
+
+// returns the version of the aggregated rates, derived from the rate of
+// every day like the version of a rate set
+func (a *aggregateResponse) version() string {
+	end, _ := time.Parse(currencyDateFormat, a.End)
+	return rateVersion(end, a.rates)
+}
+
+// Reports whether no rates are to come up to end after the rates of the
+// last publication. The next publication day is at most a few days after
+// the last one, so the loop ends early on periods far in the future.
+func periodFinished(last, end time.Time) bool {
+	for day := last.AddDate(0, 0, 1); !day.After(end); day = day.AddDate(0, 0, 1) {
+		if isPublicationDay(day) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Aggregates the rates of a currency pair over the days within start and
+// end. The history only holds the backfilled days and those fetched since,
+// a start before the oldest stored day is moved to it. The publication days
+// up to the current rates without a rate for the pair are counted as
+// missing, the aggregate is not finished while publication days of the
+// period are to come.
+func (s *Server) aggregate(to, from string, start, end time.Time) (r *aggregateResponse, err error) {
+	if first, found := s.history.first(); found && start.Before(first) {
+		start = first
+	}
+
+	response := newAggregate(to, from)
+	response.Start, response.End = start.Format(currencyDateFormat), end.Format(currencyDateFormat)
+
+	for _, day := range s.history.between(start, end) {
+		response.add(day)
+	}
+
+	if response.Days == 0 {
+		return nil, fmt.Errorf("No rates for %s/%s from %s to %s", from, to, response.Start, response.End)
+	}
+
+	// no rates are expected after the current ones
+	s.rateMutex.RLock()
+	last := s.lastUpdateTime
+	s.rateMutex.RUnlock()
+	response.Finished = periodFinished(last, end)
+	if end.Before(last) {
+		last = end
+	}
+
+	for day := start; !day.After(last); day = day.AddDate(0, 0, 1) {
+		if _, found := response.rates[day.Format(currencyDateFormat)]; !found && isPublicationDay(day) {
+			response.MissingDays++
+		}
+	}
+
+	return response, nil
+}
+
+// Parses a period, either a year ("2016"), a month ("2016-04") or an
+// interval of dates ("2016-04-01/2016-04-15"). Returns the first and the
+// last day of the period.
+func parsePeriod(period string) (start, end time.Time, err error) {
+	if len(period) == 21 && period[10] == '/' {
+		start, err = time.Parse(currencyDateFormat, period[:10])
+		if err != nil {
+			return start, end, fmt.Errorf("Invalid period: %s", period)
+		}
+
+		end, err = time.Parse(currencyDateFormat, period[11:])
+		if err != nil || end.Before(start) {
+			return start, end, fmt.Errorf("Invalid period: %s", period)
+		}
+
+		return start, end, nil
+	}
+
+	if start, err = time.Parse("2006-01", period); err == nil {
+		return start, start.AddDate(0, 1, -1), nil
+	}
+
+	if start, err = time.Parse("2006", period); err == nil {
+		return start, start.AddDate(1, 0, -1), nil
+	}
+
+	return start, end, fmt.Errorf("Invalid period: %s", period)
+}
+
+// Parses a range given as start and end dates. A missing end is today and
+// a missing start is 30 days before the end.
+func parseRange(startStr, endStr string) (start, end time.Time, err error) {
+	end = time.Now().UTC().Truncate(24 * time.Hour)
+	if endStr != "" {
+		end, err = time.Parse(currencyDateFormat, endStr)
+		if err != nil {
+			return start, end, fmt.Errorf("Invalid end: %s", endStr)
+		}
+	}
+
+	start = end.AddDate(0, 0, -30)
+	if startStr != "" {
+		start, err = time.Parse(currencyDateFormat, startStr)
+		if err != nil {
+			return start, end, fmt.Errorf("Invalid start: %s", startStr)
+		}
+	}
+
+	if end.Before(start) {
+		return start, end, fmt.Errorf("Start after end: %s", startStr)
+	}
+
+	return start, end, nil
+}
+
+// Handles the aggregation requests (/aggregate)
+func (s *Server) aggregateHandler(w http.ResponseWriter, r *http.Request) {
+	// only handle GET, error on everything else
+	if r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+
+	// a named period takes precedence over start and end
+	var start, end time.Time
+	var err error
+	if period := q.Get("period"); period != "" {
+		start, end, err = parsePeriod(period)
+	} else {
+		start, end, err = parseRange(q.Get("start"), q.Get("end"))
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.aggregate(q.Get("to"), q.Get("from"), start, end)
+	s.respondJson(w, res, err)
+}
+
+// Creates a conversion response using the average or closing rate of a
+// period from the history instead of the current rates. Fails unless the
+// period has finished and the history has the rates of every publication
+// day of the period, the version identifies the daily rates used. The
+// overrides only apply to the current rates, the mismatches are listed if
+// the period includes them.
+func (s *Server) createPeriodConvertResponse(to, from string, amounts []float64, rateType, period string) (r *convertResponse, err error) {
+	start, end, err := parsePeriod(period)
+	if err != nil {
+		return nil, err
+	}
+
+	agg, err := s.aggregate(to, from, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	if !agg.Finished {
+		return nil, fmt.Errorf("Period %s has not finished", period)
+	}
+
+	if agg.Start != start.Format(currencyDateFormat) {
+		return nil, fmt.Errorf("History starts on %s, after the start of %s", agg.Start, period)
+	}
+
+	if agg.MissingDays > 0 {
+		return nil, fmt.Errorf("Rates for %s/%s missing on %d days of %s", from, to, agg.MissingDays, period)
+	}
+
+	response := convertResponse{}
+	response.BaseCurrency = from
+	response.TargetCurrency = to
+	response.CurrencyDate = agg.CloseDate
+	response.RateVersion = agg.version()
+	response.RateType = rateType
+	response.Period = period
+
+	s.rateMutex.RLock()
+	response.Provider = s.provider
+	if agg.CloseDate == s.lastUpdateTime.Format(currencyDateFormat) {
+		response.Mismatches = s.mismatching(from, to)
+	}
+	s.rateMutex.RUnlock()
+
+	switch rateType {
+	case rateTypeAverage:
+		response.Rate = agg.Average
+	case rateTypeClosing:
+		response.Rate = agg.Close
+	default:
+		return nil, fmt.Errorf("Unknown rate type: %s", rateType)
+	}
+
+	response.InverseRate = 1 / response.Rate
+	for _, amount := range amounts {
+		response.ConvertedAmounts = append(response.ConvertedAmounts, amount*response.Rate)
+	}
+
+	return &response, nil
+}
+
+// Fetches the history from the given URL and adds every day to the history.
+func (s *Server) backfillHistory(ctx context.Context, url string, timeout time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	data, err := fetchCurrencyData(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	days, err := parseHistoryData(data)
+	if err != nil {
+		return err
+	}
+
+	for _, day := range days {
+		s.history.add(day.date, day.rates)
+	}
+
+	return nil
+}
+
+// Parse the raw history data from the ECB. Returns the days with the rates
+// including EUR as "1".
+func parseHistoryData(data []byte) (days []historyDay, err error) {
+	var h historyEnvelope
+	err = xml.Unmarshal(data, &h)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range h.Days {
+		ts, err := time.Parse(currencyDateFormat, c.Time)
+		if err != nil {
+			return nil, err
+		}
+
+		rates := make(map[string]float64)
+		rates[eur] = 1
+		for _, currency := range c.Rates {
+			rates[currency.Name] = currency.Rate
+		}
+
+		days = append(days, historyDay{date: ts, rates: rates})
+	}
+
+	return days, nil
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const historyXML = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>
+		<Cube time="2001-02-28"><Cube currency="USD" rate="1.25"/><Cube currency="DKK" rate="7.5"/></Cube>
+		<Cube time="2001-02-27"><Cube currency="USD" rate="1.5"/><Cube currency="DKK" rate="7.5"/></Cube>
+		<Cube time="2001-02-01"><Cube currency="USD" rate="1"/><Cube currency="DKK" rate="7.5"/></Cube>
+		<Cube time="2001-01-31"><Cube currency="USD" rate="2"/><Cube currency="DKK" rate="7.5"/></Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+var (
+	testHistory []historyDay
+)
+
+func init() {
+	days, err := parseHistoryData([]byte(historyXML))
+	if err != nil {
+		panic(err)
+	}
+
+	testHistory = days
+}
+
+// adds the test history to the server
+func addTestHistory() {
+	for _, day := range testHistory {
+		server.history.add(day.date, day.rates)
+	}
+}
+
+func TestParseHistoryData(t *testing.T) {
+	if len(testHistory) != 4 {
+		t.Fatal("Expected four days, got:", len(testHistory))
+	}
+
+	if testHistory[0].rates[eur] != 1 || testHistory[0].rates["USD"] != 1.25 {
+		t.Fatal("Unexpected rates:", testHistory[0].rates)
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	periods := map[string][2]string{
+		"2001-02":               {"2001-02-01", "2001-02-28"},
+		"2000":                  {"2000-01-01", "2000-12-31"},
+		"2001-01-15/2001-02-15": {"2001-01-15", "2001-02-15"},
+	}
+
+	for period, expected := range periods {
+		start, end, err := parsePeriod(period)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if start.Format(currencyDateFormat) != expected[0] || end.Format(currencyDateFormat) != expected[1] {
+			t.Fatal("Unexpected period:", period, start, end)
+		}
+	}
+
+	for _, period := range []string{"", "2001-13", "2001-02-15/2001-01-15", "yesterday"} {
+		if _, _, err := parsePeriod(period); err == nil {
+			t.Fatal("Expected invalid period:", period)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	addTestHistory()
+	start, end, _ := parsePeriod("2001-02")
+	agg, err := server.aggregate("DKK", "USD", start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if agg.Days != 3 || agg.Open != 7.5 || agg.Close != 6 || agg.Min != 5 || agg.Max != 7.5 {
+		t.Fatal("Unexpected aggregate:", agg)
+	}
+
+	if agg.Average != (7.5+5+6)/3 || agg.OpenDate != "2001-02-01" || agg.CloseDate != "2001-02-28" {
+		t.Fatal("Unexpected aggregate:", agg)
+	}
+
+	// 3 of the 20 publication days are in the history
+	if agg.MissingDays != 17 {
+		t.Fatal("Expected missing days:", agg.MissingDays)
+	}
+
+	start, end, _ = parsePeriod("2001-02-27/2001-03-04")
+	if agg, err := server.aggregate("DKK", "USD", start, end); err != nil || agg.MissingDays != 2 {
+		t.Fatal("Expected the first days of march to be missing:", agg, err)
+	}
+
+	if _, err := server.aggregate("DKK", "FOO", start, end); err == nil {
+		t.Fatal("Expected error for unknown currency")
+	}
+
+	start, end, _ = parsePeriod("1999")
+	if _, err := server.aggregate("DKK", "USD", start, end); err == nil {
+		t.Fatal("Expected error for empty period")
+	}
+
+	// the start is moved to the oldest stored day
+	start, _ = time.Parse(currencyDateFormat, "0001-01-01")
+	end, _ = time.Parse(currencyDateFormat, "2001-02-01")
+	agg, err = server.aggregate("DKK", "USD", start, end)
+	if err != nil || agg.Start != "2001-01-31" || agg.MissingDays != 0 {
+		t.Fatal("Expected the start of the history:", agg, err)
+	}
+}
+
+func TestPeriodFinished(t *testing.T) {
+	cases := map[string]bool{
+		"2026-10-16": true,  // the friday of the last rates
+		"2026-10-18": true,  // no rates on the weekend
+		"2026-10-19": false, // monday's rates are to come
+		"9999-12-31": false,
+	}
+
+	last, _ := time.Parse(currencyDateFormat, "2026-10-16")
+	for date, expected := range cases {
+		end, _ := time.Parse(currencyDateFormat, date)
+		if periodFinished(last, end) != expected {
+			t.Fatal("Unexpected finished period:", date, !expected)
+		}
+	}
+}
+
+func TestAggregateHandler(t *testing.T) {
+	addTestHistory()
+	var agg aggregateResponse
+	r := fireReq("/aggregate?from=USD&to=DKK&start=2001-01-31&end=2001-02-27", http.MethodGet, nil)
+	expect(t, r, http.StatusOK, true, &agg)
+	if agg.Days != 3 || agg.Close != 5 || !agg.Finished {
+		t.Fatal("Unexpected aggregate:", agg)
+	}
+
+	r = fireReq("/aggregate?from=USD&to=DKK&period=2001-13", http.MethodGet, nil)
+	expect(t, r, http.StatusBadRequest, true, nil)
+}
+
+func TestConvertPeriodRates(t *testing.T) {
+	addTestHistory()
+	var res convertResponse
+	r := fireReq("/convert", http.MethodPost, convertRequest{
+		BaseCurrency:   "USD",
+		TargetCurrency: "DKK",
+		Amounts:        []float64{10},
+		RateType:       rateTypeClosing,
+		Period:         "2001-02-27/2001-02-28",
+	})
+	expect(t, r, http.StatusOK, true, &res)
+	if res.Rate != 6 || res.ConvertedAmounts[0] != 60 || res.CurrencyDate != "2001-02-28" || res.RateVersion == "" {
+		t.Fatal("Unexpected closing conversion:", res)
+	}
+	version := res.RateVersion
+
+	r = fireReq("/convert", http.MethodPost, convertRequest{
+		BaseCurrency:   "USD",
+		TargetCurrency: "DKK",
+		Amounts:        []float64{10},
+		RateType:       rateTypeAverage,
+		Period:         "2001-02-27/2001-02-28",
+	})
+	expect(t, r, http.StatusOK, true, &res)
+	if res.Rate != 5.5 || res.RateVersion != version {
+		t.Fatal("Unexpected average conversion:", res)
+	}
+
+	// the history lacks most days of january
+	r = fireReq("/convert", http.MethodPost, convertRequest{
+		BaseCurrency:   "USD",
+		TargetCurrency: "DKK",
+		Amounts:        []float64{10},
+		RateType:       rateTypeClosing,
+		Period:         "2001-01",
+	})
+	expect(t, r, http.StatusInternalServerError, true, nil)
+
+	// the week after the current rates has not finished
+	last := server.lastUpdateTime
+	_, err := server.createPeriodConvertResponse("DKK", "USD", []float64{10}, rateTypeAverage,
+		last.Format(currencyDateFormat)+"/"+last.AddDate(0, 0, 7).Format(currencyDateFormat))
+	if err == nil || !strings.Contains(err.Error(), "not finished") {
+		t.Fatal("Expected the period to be unfinished:", err)
+	}
+
+	r = fireReq("/convert", http.MethodPost, convertRequest{
+		BaseCurrency:   "USD",
+		TargetCurrency: "DKK",
+		Amounts:        []float64{10},
+		RateType:       "median",
+		Period:         "2001-01",
+	})
+	expect(t, r, http.StatusInternalServerError, true, nil)
+}
+
+func TestHistoryFromUpdates(t *testing.T) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	days := server.history.between(server.lastUpdateTime, today)
+	if len(days) == 0 {
+		t.Fatal("Expected the current rates in the history")
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLogLevel  = "info"
+	defaultLogFormat = "json"
+
+	requestIdHeader = "X-Request-ID"
+)
+
+// the request IDs taken from clients, anything else is replaced
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// the key of the request logger in the request context
+type requestLoggerKey struct{}
+
+// Creates the logger writing to out. The format is json or text, the level
+// debug, info, warn or error.
+func newLogger(out io.Writer, format, level string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("Unknown log level: %s", level)
+	}
+
+	options := &slog.HandlerOptions{Level: l}
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.New(slog.NewJSONHandler(out, options)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(out, options)), nil
+	}
+
+	return nil, fmt.Errorf("Unknown log format: %s", format)
+}
+
+// Returns the request ID of the client if it is valid, a new one otherwise.
+func requestId(r *http.Request) string {
+	id := r.Header.Get(requestIdHeader)
+	if validRequestId.MatchString(id) {
+		return id
+	}
+
+	id, _ = randomHex(8)
+	return id
+}
+
+// returns the logger of the request, carrying its ID
+func requestLogger(r *http.Request) *slog.Logger {
+	if logger, ok := r.Context().Value(requestLoggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
+
+// returns the logger of the webhook events
+func (s *Server) webhookLogger(hook webhook) *slog.Logger {
+	return s.logger.With("component", "webhooks", "url", hook.Url, "base_currency", hook.BaseCurrency)
+}
+
+// returns the request with the logger of the server carrying its ID in the
+// context
+func (s *Server) withRequestId(r *http.Request, id string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestLoggerKey{}, s.logger.With("request_id", id)))
+}
+
+// Writes the access log entry of the request. Server errors are logged as
+// errors, the query is left out as it may carry an API key.
+func accessLog(r *http.Request, w *statusWriter, d time.Duration) {
+	level := slog.LevelInfo
+	if w.code() >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+
+	sc, _ := r.Context().Value(spanContextKey{}).(spanContext)
+	requestLogger(r).LogAttrs(r.Context(), level, "Request",
+		slog.String("trace_id", sc.traceId),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", w.code()),
+		slog.Float64("duration_ms", millis(d)),
+		slog.Int64("bytes", w.bytes),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("user_agent", r.UserAgent()),
+	)
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// a buffer safe for the concurrent writes of the logger
+type logBuffer struct {
+	mutex sync.Mutex
+	b     bytes.Buffer
+}
+
+func (l *logBuffer) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.b.Write(p)
+}
+
+func (l *logBuffer) String() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.b.String()
+}
+
+func TestNewLogger(t *testing.T) {
+	b := &logBuffer{}
+	logger, err := newLogger(b, "json", "warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("hidden")
+	logger.Warn("shown", "currency", "USD")
+	if strings.Contains(b.String(), "hidden") || !strings.Contains(b.String(), `"currency":"USD"`) {
+		t.Fatal("Unexpected log:", b.String())
+	}
+
+	if _, err := newLogger(b, "xml", "info"); err == nil {
+		t.Fatal("Expected an error for the format")
+	}
+
+	if _, err := newLogger(b, "text", "loud"); err == nil {
+		t.Fatal("Expected an error for the level")
+	}
+}
+
+// fires the request at a copy of the test server logging to the logger
+func fireLoggedReq(logger *slog.Logger, endpoint string, headers map[string]string) *httptest.ResponseRecorder {
+	s := *server
+	s.logger = logger
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, endpoint, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAccessLog(t *testing.T) {
+	b := &logBuffer{}
+	logger, _ := newLogger(b, "json", "info")
+
+	r := fireLoggedReq(logger, "/currencies", map[string]string{requestIdHeader: "abc-123"})
+	expect(t, r, http.StatusOK, true, nil)
+	if r.Header().Get(requestIdHeader) != "abc-123" {
+		t.Fatal("Expected the request ID to be propagated:", r.Header().Get(requestIdHeader))
+	}
+
+	var entry struct {
+		Level     string  `json:"level"`
+		Msg       string  `json:"msg"`
+		RequestId string  `json:"request_id"`
+		Path      string  `json:"path"`
+		Status    int     `json:"status"`
+		Bytes     int     `json:"bytes"`
+		Duration  float64 `json:"duration_ms"`
+	}
+	for _, line := range strings.Split(b.String(), "\n") {
+		if strings.Contains(line, `"request_id":"abc-123"`) && strings.Contains(line, `"msg":"Request"`) {
+			json.Unmarshal([]byte(line), &entry)
+		}
+	}
+
+	if entry.Level != "INFO" || entry.Path != "/currencies" || entry.Status != http.StatusOK || entry.Bytes != r.Body.Len() {
+		t.Fatal("Unexpected access log:", entry, b.String())
+	}
+
+	// invalid IDs are replaced
+	r = fireLoggedReq(logger, "/currencies", map[string]string{requestIdHeader: "bad id\n"})
+	if id := r.Header().Get(requestIdHeader); id == "" || id == "bad id\n" {
+		t.Fatal("Expected a new request ID:", id)
+	}
+}
@@ -0,0 +1,321 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the buckets of the latency histograms in seconds
+var (
+	requestBuckets  = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+	outboundBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+)
+
+// the routes with their own label, other paths are counted as "other" to
+// keep the number of series bounded
+var metricRoutes = []string{
+	"/currencies", "/convert", "/webhook", "/script", "/script.d.ts", "/aggregate", "/timeseries",
+	"/chart.svg", "/catalogue", "/admin/overrides", "/admin/keys", "/admin/webhooks", "/metrics", "/debug/vars",
+	"/healthz", "/readyz",
+}
+
+// A set of series of one metric, keyed by the label values. A counter
+// holds one value per series, a histogram the bucket counts, sum and count.
+type metricVec struct {
+	name    string
+	help    string
+	kind    string // counter or histogram
+	labels  []string
+	buckets []float64
+	series  map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labels []string
+	value  float64  // the counter value or the histogram sum
+	counts []uint64 // the histogram bucket counts, not cumulative
+	count  uint64
+}
+
+// The metrics of the server, written in the Prometheus text format.
+type metrics struct {
+	mutex *sync.Mutex
+
+	requests         *metricVec
+	requestDuration  *metricVec
+	fetches          *metricVec
+	fetchDuration    *metricVec
+	deliveries       *metricVec
+	deliveryDuration *metricVec
+
+	queueDepth int // webhooks left in the current run
+}
+
+func newMetricVec(name, help, kind string, buckets []float64, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: make(map[string]*metricSeries)}
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		mutex: &sync.Mutex{},
+
+		requests: newMetricVec("currency_http_requests_total",
+			"Requests by route and status.", "counter", nil, "route", "status"),
+		requestDuration: newMetricVec("currency_http_request_duration_seconds",
+			"Request latency by route and status.", "histogram", requestBuckets, "route", "status"),
+		fetches: newMetricVec("currency_fetches_total",
+			"Rate fetches by provider and result.", "counter", nil, "provider", "result"),
+		fetchDuration: newMetricVec("currency_fetch_duration_seconds",
+			"Rate fetch duration by provider.", "histogram", outboundBuckets, "provider"),
+		deliveries: newMetricVec("currency_webhook_deliveries_total",
+			"Webhook calls by kind and result.", "counter", nil, "kind", "result"),
+		deliveryDuration: newMetricVec("currency_webhook_delivery_duration_seconds",
+			"Webhook call latency by kind.", "histogram", outboundBuckets, "kind"),
+	}
+}
+
+// returns the series of the label values, creates it if needed
+func (m *metricVec) with(values ...string) *metricSeries {
+	key := strings.Join(values, "\xff")
+	series, found := m.series[key]
+	if !found {
+		series = &metricSeries{labels: values, counts: make([]uint64, len(m.buckets))}
+		m.series[key] = series
+	}
+
+	return series
+}
+
+// adds the value to the counter
+func (m *metricVec) add(value float64, labels ...string) {
+	m.with(labels...).value += value
+}
+
+// adds the observation to the histogram
+func (m *metricVec) observe(value float64, labels ...string) {
+	series := m.with(labels...)
+	for i, bound := range m.buckets {
+		if value <= bound {
+			series.counts[i]++
+			break
+		}
+	}
+
+	series.value += value
+	series.count++
+}
+
+// writes the metric with its series sorted by labels
+func (m *metricVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := m.series[key]
+		if m.kind == "counter" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, series.labels), formatMetric(series.value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += series.counts[i]
+			labels := formatLabels(append(m.labels, "le"), append(series.labels, formatMetric(bound)))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, labels, cumulative)
+		}
+
+		labels := formatLabels(append(m.labels, "le"), append(series.labels, "+Inf"))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, labels, series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatLabels(m.labels, series.labels), formatMetric(series.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatLabels(m.labels, series.labels), series.count)
+	}
+}
+
+// writes a single metric of the type without labels
+func writeSingle(w io.Writer, name, help, kind string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, formatMetric(value))
+}
+
+// writes a single gauge
+func writeGauge(w io.Writer, name, help string, value float64) {
+	writeSingle(w, name, help, "gauge", value)
+}
+
+// writes a single counter
+func writeCounter(w io.Writer, name, help string, value float64) {
+	writeSingle(w, name, help, "counter", value)
+}
+
+// formats the labels as {name="value",...}, empty without labels
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(values[i])
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, value)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formats a metric value, the shortest representation
+func formatMetric(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// returns the route label of the path
+func routeLabel(path string) string {
+	for _, route := range metricRoutes {
+		if path == route {
+			return route
+		}
+	}
+
+	return "other"
+}
+
+// records a handled request
+func (m *metrics) observeRequest(route string, status int, d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.requests.add(1, route, strconv.Itoa(status))
+	m.requestDuration.observe(d.Seconds(), route, strconv.Itoa(status))
+}
+
+// records a fetch from a provider
+func (m *metrics) observeFetch(provider string, err error, d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.fetches.add(1, provider, result(err))
+	m.fetchDuration.observe(d.Seconds(), provider)
+}
+
+// records a webhook call, the latency is in milliseconds
+func (m *metrics) observeDelivery(kind string, err error, latency float64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.deliveries.add(1, kind, result(err))
+	m.deliveryDuration.observe(latency/1000, kind)
+}
+
+// sets the number of webhooks left in the current run
+func (m *metrics) setQueueDepth(depth int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.queueDepth = depth
+}
+
+// returns the result label of the error
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+
+	return "success"
+}
+
+// A response writer remembering the status code and the bytes written.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// returns the status code, 200 if nothing has been written
+func (w *statusWriter) code() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
+
+// Handles the Prometheus metrics (/metrics). Next to the recorded metrics
+// the age of the rates and the webhooks by status are reported.
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	b := bufio.NewWriter(w)
+	defer b.Flush()
+
+	s.metrics.mutex.Lock()
+	for _, m := range []*metricVec{s.metrics.requests, s.metrics.requestDuration, s.metrics.fetches,
+		s.metrics.fetchDuration, s.metrics.deliveries, s.metrics.deliveryDuration} {
+		m.write(b)
+	}
+	writeGauge(b, "currency_webhook_queue_depth", "Webhooks left in the current delivery run.", float64(s.metrics.queueDepth))
+	s.metrics.mutex.Unlock()
+
+	// the rates and the webhooks have their own locks, neither is held
+	// during the webhook calls
+	s.rateMutex.RLock()
+	hasCurrencies, lastUpdate, provider := s.hasCurrencies, s.lastUpdateTime, s.provider
+	s.rateMutex.RUnlock()
+
+	s.mutex.Lock()
+	statuses := map[string]int{webhookPending: 0, webhookActive: 0, webhookDisabled: 0}
+	for _, hook := range s.webhooks {
+		statuses[hook.Status]++
+	}
+	statuses[webhookPending] += len(s.pending)
+	s.mutex.Unlock()
+
+	available, age := 0.0, 0.0
+	if hasCurrencies {
+		available = 1
+		age = time.Since(publicationTime(lastUpdate)).Seconds()
+		if age < 0 {
+			age = 0
+		}
+	}
+
+	writeGauge(b, "currency_rates_available", "1 if rates have been fetched.", available)
+	writeGauge(b, "currency_rates_age_seconds", "Seconds since the publication of the current rates.", age)
+	if hasCurrencies {
+		writeGauge(b, "currency_rates_timestamp_seconds", "Publication time of the current rates.", float64(publicationTime(lastUpdate).Unix()))
+		fmt.Fprintf(b, "# HELP currency_rates_provider Provider of the current rates.\n# TYPE currency_rates_provider gauge\n")
+		fmt.Fprintf(b, "currency_rates_provider%s 1\n", formatLabels([]string{"provider"}, []string{provider}))
+	}
+
+	writeCounter(b, "currency_provider_failovers_total", "Fetches where a fallback provider was used.", float64(s.providerFailovers.Value()))
+	writeGauge(b, "currency_rate_mismatches", "Currencies where the providers disagree.", float64(s.rateMismatches.Value()))
+
+	fmt.Fprintf(b, "# HELP currency_webhooks Registered webhooks by status.\n# TYPE currency_webhooks gauge\n")
+	for _, status := range []string{webhookActive, webhookDisabled, webhookPending} {
+		fmt.Fprintf(b, "currency_webhooks%s %d\n", formatLabels([]string{"status"}, []string{status}), statuses[status])
+	}
+}
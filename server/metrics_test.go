@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricVec(t *testing.T) {
+	m := newMetricVec("test_seconds", "Test.", "histogram", []float64{0.1, 1}, "route")
+	m.observe(0.05, "/a")
+	m.observe(0.5, "/a")
+	m.observe(5, "/a")
+
+	b := &bytes.Buffer{}
+	m.write(b)
+	for _, line := range []string{
+		`test_seconds_bucket{route="/a",le="0.1"} 1`,
+		`test_seconds_bucket{route="/a",le="1"} 2`,
+		`test_seconds_bucket{route="/a",le="+Inf"} 3`,
+		`test_seconds_sum{route="/a"} 5.55`,
+		`test_seconds_count{route="/a"} 3`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Fatal("Expected the line:", line, b.String())
+		}
+	}
+
+	if formatLabels([]string{"a"}, []string{"x\"y\\z\n"}) != `{a="x\"y\\z\n"}` {
+		t.Fatal("Unexpected escaping:", formatLabels([]string{"a"}, []string{"x\"y\\z\n"}))
+	}
+
+	if routeLabel("/currencies") != "/currencies" || routeLabel("/random/path") != "other" {
+		t.Fatal("Unexpected route labels")
+	}
+}
+
+// returns the value of the metric line starting with the name and labels,
+// zero if there is none
+func metricValue(body, metric string) float64 {
+	for _, line := range strings.Split(body, "\n") {
+		if value, found := strings.CutPrefix(line, metric+" "); found {
+			v, _ := strconv.ParseFloat(value, 64)
+			return v
+		}
+	}
+
+	return 0
+}
+
+func TestMetricsHandler(t *testing.T) {
+	// the counters are global to the test server, compare to the values
+	// before so the test can be repeated
+	const failures = `currency_fetches_total{provider="test",result="failure"}`
+	const duration = `currency_fetch_duration_seconds_sum{provider="test"}`
+	before := fireReqHeaders("/metrics", http.MethodGet, nil, adminHeaders).Body.String()
+
+	fireReq("/currencies", http.MethodGet, nil)
+	fireReq("/nothing", http.MethodGet, nil)
+	server.metrics.observeFetch("test", errors.New("failed"), time.Second)
+	server.metrics.observeDelivery(attemptDelivery, nil, 20)
+
+	r := fireReq("/metrics", http.MethodGet, nil)
+	expect(t, r, http.StatusUnauthorized, true, nil)
+
+	r = fireReqHeaders("/metrics", http.MethodGet, nil, adminHeaders)
+	expect(t, r, http.StatusOK, true, nil)
+	if !strings.HasPrefix(r.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatal("Unexpected content type:", r.Header().Get("Content-Type"))
+	}
+
+	body := r.Body.String()
+	for _, line := range []string{
+		`currency_http_requests_total{route="/currencies",status="200"} `,
+		`currency_http_requests_total{route="other",status="404"} `,
+		`currency_http_request_duration_seconds_bucket{route="/currencies",status="200",le="+Inf"} `,
+		failures + " ",
+		duration + " ",
+		`currency_webhook_deliveries_total{kind="delivery",result="success"} `,
+		`currency_webhook_queue_depth 0`,
+		`currency_rates_available 1`,
+		`currency_rates_age_seconds `,
+		`currency_webhooks{status="active"} `,
+		"# TYPE currency_provider_failovers_total counter\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatal("Expected the metric:", line)
+		}
+	}
+
+	for _, metric := range []string{failures, duration} {
+		if diff := metricValue(body, metric) - metricValue(before, metric); diff != 1 {
+			t.Fatal("Unexpected increase:", metric, diff)
+		}
+	}
+}
+
+func TestDebugVars(t *testing.T) {
+	r := fireReq("/debug/vars", http.MethodGet, nil)
+	expect(t, r, http.StatusUnauthorized, true, nil)
+
+	r = fireReqHeaders("/debug/vars", http.MethodGet, nil, adminHeaders)
+	expect(t, r, http.StatusOK, true, nil)
+	if !strings.Contains(r.Body.String(), `"currency_hits"`) {
+		t.Fatal("Expected the expvar counters")
+	}
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the response formats, the first one is the default
+const (
+	formatJson = "json"
+	formatCsv  = "csv"
+	formatXml  = "xml"
+)
+
+// the content type of every response format
+var formatContentTypes = map[string]string{
+	formatJson: "application/json",
+	formatCsv:  "text/csv; charset=utf-8",
+	formatXml:  "application/xml; charset=utf-8",
+}
+
+// the media types accepted for every response format, in order of
+// preference when a wildcard is accepted
+var formatMediaTypes = []struct {
+	mediaType string
+	format    string
+}{
+	{"application/json", formatJson},
+	{"text/csv", formatCsv},
+	{"application/xml", formatXml},
+	{"text/xml", formatXml},
+}
+
+// a response that can be written as CSV, the first record is the header
+type csvResponse interface {
+	csvRecords() [][]string
+}
+
+// the envelope of the XML responses, shaped like the ECB reference rates
+type xmlEnvelope struct {
+	XMLName xml.Name    `xml:"Envelope"`
+	Subject string      `xml:"subject"`
+	Sender  string      `xml:"Sender>name"`
+	Cube    interface{} `xml:"Cube>Cube"`
+}
+
+// Returns the format of the response. The format parameter takes precedence
+// over the Accept header, JSON is returned if neither is given. Returns an
+// error if none of the requested formats are supported.
+func negotiateFormat(r *http.Request) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		format = strings.ToLower(format)
+		if _, found := formatContentTypes[format]; !found {
+			return "", fmt.Errorf("Unknown format: %s", format)
+		}
+
+		return format, nil
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return formatJson, nil
+	}
+
+	// the accepted media types with their quality, stable sorted so the
+	// order of the header decides between equal qualities
+	type acceptRange struct {
+		mediaType string
+		quality   float64
+	}
+
+	var ranges []acceptRange
+	refused := make(map[string]bool)
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		ar := acceptRange{mediaType: strings.ToLower(strings.TrimSpace(params[0])), quality: 1}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				if err == nil {
+					ar.quality = q
+				}
+			}
+		}
+
+		// a quality of 0 refuses the media type, even if matched by a wildcard
+		if ar.quality > 0 {
+			ranges = append(ranges, ar)
+		} else {
+			refused[ar.mediaType] = true
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+
+	for _, ar := range ranges {
+		for _, mt := range formatMediaTypes {
+			if !refused[mt.mediaType] && mediaTypeMatches(ar.mediaType, mt.mediaType) {
+				return mt.format, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("No acceptable format: %s", accept)
+}
+
+// returns true if the accepted media range (eg. text/*) matches the media type
+func mediaTypeMatches(accepted, mediaType string) bool {
+	if accepted == "*/*" || accepted == mediaType {
+		return true
+	}
+
+	return strings.HasSuffix(accepted, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(accepted, "*"))
+}
+
+// writes v in the given format, fails if v can not be written in the format
+func encodeResponse(w io.Writer, format, subject string, v interface{}) error {
+	switch format {
+	case formatCsv:
+		res, ok := v.(csvResponse)
+		if !ok {
+			return fmt.Errorf("Response can not be written as CSV")
+		}
+
+		cw := csv.NewWriter(w)
+		cw.WriteAll(res.csvRecords())
+		return cw.Error()
+	case formatXml:
+		io.WriteString(w, xml.Header)
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		return enc.Encode(xmlEnvelope{Subject: subject, Sender: "Currency Converter", Cube: v})
+	default:
+		return json.NewEncoder(w).Encode(v)
+	}
+}
+
+// Responds with v in the negotiated format unless the client has the
+// response cached already. The subject names the XML envelope. JSON is
+// wrapped in the callback parameter if JSONP is enabled.
+func (s *Server) respondNegotiated(w http.ResponseWriter, r *http.Request, key, subject string, v interface{}, err error) {
+	w.Header().Add("Vary", "Accept")
+
+	format, ferr := negotiateFormat(r)
+	if ferr != nil {
+		http.Error(w, ferr.Error(), http.StatusNotAcceptable)
+		return
+	}
+
+	if err != nil {
+		requestLogger(r).Error("Error creating response", "error", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	// wrap JSON in the callback if JSONP is enabled
+	callback := r.URL.Query().Get("callback")
+	if !s.jsonp || format != formatJson {
+		callback = ""
+	} else if callback != "" && !jsonpCallback.MatchString(callback) {
+		http.Error(w, "Invalid callback", http.StatusBadRequest)
+		return
+	}
+
+	key += "." + format
+	if callback != "" {
+		key += "-" + callback
+	}
+
+	if s.cacheResponse(w, r, key) {
+		return
+	}
+
+	// encode before writing so errors can still be reported
+	b := &strings.Builder{}
+	err = encodeResponse(b, format, subject, v)
+	if err != nil {
+		requestLogger(r).Error("Error encoding response", "format", format, "error", err)
+		http.Error(w, "Error creating response.", http.StatusInternalServerError)
+		return
+	}
+
+	if callback != "" {
+		// the comment guards against content sniffing of the callback
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		fmt.Fprintf(w, "/**/ %s(%s);\n", callback, strings.TrimSpace(b.String()))
+		return
+	}
+
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	io.WriteString(w, b.String())
+}
+
+// returns the records of the rates, one row per rate
+func (res *currencyResponse) csvRecords() [][]string {
+	records := [][]string{{"currency_date", "base_currency", "rate_version", "provider", "name", "rate", "source"}}
+	for _, rate := range res.Rates {
+		records = append(records, []string{res.CurrencyDate, res.BaseCurrency, res.RateVersion, res.Provider,
+			rate.Name, formatCsvFloat(rate.Rate), rate.Source})
+	}
+
+	return records
+}
+
+// returns the records of the conversion, one row per converted amount with
+// the details and formatted amounts if present
+func (res *convertResponse) csvRecords() [][]string {
+	header := []string{"currency_date", "base_currency", "target_currency", "rate_version", "rate", "inverse_rate", "provider", "converted_amount"}
+	if len(res.FormattedAmounts) > 0 {
+		header = append(header, "formatted_amount")
+	}
+	if len(res.Details) > 0 {
+		header = append(header, "amount", "mid_rate", "applied_rate", "converted", "fee", "total")
+	}
+
+	records := [][]string{header}
+	for i, converted := range res.ConvertedAmounts {
+		record := []string{res.CurrencyDate, res.BaseCurrency, res.TargetCurrency, res.RateVersion,
+			formatCsvFloat(res.Rate), formatCsvFloat(res.InverseRate), res.Provider, formatCsvFloat(converted)}
+		if len(res.FormattedAmounts) > 0 {
+			record = append(record, res.FormattedAmounts[i])
+		}
+		if len(res.Details) > 0 {
+			d := res.Details[i]
+			record = append(record, formatCsvFloat(d.Amount), formatCsvFloat(d.MidRate), formatCsvFloat(d.AppliedRate),
+				formatCsvFloat(d.Converted), formatCsvFloat(d.Fee), formatCsvFloat(d.Total))
+		}
+
+		records = append(records, record)
+	}
+
+	return records
+}
+
+// formats a float for CSV, the shortest representation without exponent
+func formatCsvFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	cases := []struct {
+		url, accept, format string
+	}{
+		{"/currencies", "", formatJson},
+		{"/currencies", "*/*", formatJson},
+		{"/currencies", "text/csv", formatCsv},
+		{"/currencies", "text/*", formatCsv},
+		{"/currencies", "text/xml", formatXml},
+		{"/currencies", "application/json;q=0.5, application/xml", formatXml},
+		{"/currencies", "text/html, application/xml;q=0.9, */*;q=0.8", formatXml},
+		{"/currencies", "application/json;q=0, */*", formatCsv},
+		{"/currencies?format=CSV", "application/json", formatCsv},
+		{"/currencies", "image/png", ""},
+		{"/currencies?format=yaml", "", ""},
+	}
+
+	for _, c := range cases {
+		r, _ := http.NewRequest(http.MethodGet, c.url, nil)
+		r.Header.Set("Accept", c.accept)
+
+		format, err := negotiateFormat(r)
+		if format != c.format || (err != nil) != (c.format == "") {
+			t.Fatal("Unexpected format:", c.url, c.accept, format, err)
+		}
+	}
+}
+
+func TestCurrenciesCsv(t *testing.T) {
+	r := fireReq("/currencies?format=csv", http.MethodGet, nil)
+	expect(t, r, http.StatusOK, true, nil)
+
+	if r.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatal("Unexpected content type:", r.Header().Get("Content-Type"))
+	}
+
+	records, err := csv.NewReader(r.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if records[0][4] != "name" || len(records) != len(server.currencies)+1 {
+		t.Fatal("Unexpected records:", records)
+	}
+}
+
+func TestCurrenciesXml(t *testing.T) {
+	r := fireReqHeaders("/currencies", http.MethodGet, nil, map[string]string{"Accept": "application/xml"})
+	expect(t, r, http.StatusOK, true, nil)
+
+	var envelope struct {
+		Subject string `xml:"subject"`
+		Cube    struct {
+			Time  string `xml:"time,attr"`
+			Base  string `xml:"base,attr"`
+			Rates []struct {
+				Currency string  `xml:"currency,attr"`
+				Rate     float64 `xml:"rate,attr"`
+			} `xml:"Cube"`
+		} `xml:"Cube>Cube"`
+	}
+
+	err := xml.NewDecoder(r.Body).Decode(&envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if envelope.Cube.Base != eur || envelope.Cube.Time == "" || len(envelope.Cube.Rates) != len(server.currencies) {
+		t.Fatal("Unexpected envelope:", envelope)
+	}
+}
+
+func TestCurrenciesNotAcceptable(t *testing.T) {
+	r := fireReqHeaders("/currencies", http.MethodGet, nil, map[string]string{"Accept": "image/png"})
+	expect(t, r, http.StatusNotAcceptable, true, nil)
+}
+
+func TestConvertCsv(t *testing.T) {
+	req := convertRequest{BaseCurrency: "EUR", TargetCurrency: "DKK", Amounts: []float64{1, 2}, Locale: "da"}
+	r := fireReqHeaders("/convert", http.MethodPost, req, map[string]string{"Accept": "text/csv"})
+	expect(t, r, http.StatusOK, true, nil)
+
+	records, err := csv.NewReader(r.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 3 || records[0][7] != "converted_amount" || records[0][8] != "formatted_amount" {
+		t.Fatal("Unexpected records:", records)
+	}
+
+	if !strings.HasSuffix(records[2][8], "kr.") {
+		t.Fatal("Unexpected formatted amount:", records[2][8])
+	}
+}
+
+func TestConvertCacheKeyByFormat(t *testing.T) {
+	req := convertRequest{BaseCurrency: "EUR", TargetCurrency: "DKK", Amounts: []float64{1}}
+	json := fireReq("/convert", http.MethodPost, req)
+	xml := fireReq("/convert?format=xml", http.MethodPost, req)
+
+	if json.Header().Get("ETag") == xml.Header().Get("ETag") {
+		t.Fatal("Expected an ETag per format")
+	}
+}
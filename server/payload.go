@@ -0,0 +1,285 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"text/template"
+	"text/template/parse"
+)
+
+// the payload formats of the webhooks
+const (
+	payloadJson     = "json"     // the currency response
+	payloadCsv      = "csv"      // the currency response as CSV
+	payloadForm     = "form"     // form encoded, rates as rates[CODE]
+	payloadTemplate = "template" // rendered from the webhook's Go template
+
+	maxTemplateSize        = 16 * 1024 // max size of a webhook template
+	maxPayloadSize         = 1 << 20   // max size of a rendered template
+	defaultTemplateContent = "text/plain; charset=utf-8"
+)
+
+// An error in the payload settings of a webhook, the webhook is rejected
+// rather than failing.
+type payloadError struct {
+	reason string
+}
+
+func (e *payloadError) Error() string {
+	return e.reason
+}
+
+// returns true if the error rejects the webhook settings rather than being
+// a failure of the server
+func rejectedWebhook(err error) bool {
+	switch err.(type) {
+	case *policyError, *payloadError:
+		return true
+	}
+
+	return false
+}
+
+// the data the webhook templates are rendered with
+type payloadData struct {
+	Date     string             // the date of the rates (YYYY-MM-DD)
+	Base     string             // the base currency of the webhook
+	Version  string             // the version of the rates
+	Provider string             // the provider of the rates
+	Rates    []rateResponse     // the rates, filtered and sorted by name
+	Rate     map[string]float64 // the rates by currency
+}
+
+// the functions available in the webhook templates
+var payloadFuncs = template.FuncMap{
+	// encodes the value as JSON, eg. to quote strings in JSON templates
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	// formats the amount for the locale, eg. {{ format 1.5 "USD" "en" }}
+	"format": func(amount float64, currency, locale string) string {
+		return formatAmount(amount, currency, locale)
+	},
+}
+
+// A writer failing once more than its limit has been written, rendering
+// stops at the first write past the limit.
+type limitedWriter struct {
+	w    io.Writer
+	left int
+}
+
+func (l *limitedWriter) Write(b []byte) (int, error) {
+	if len(b) > l.left {
+		return 0, &payloadError{fmt.Sprintf("Payload larger than %d bytes", maxPayloadSize)}
+	}
+
+	l.left -= len(b)
+	return l.w.Write(b)
+}
+
+// Parses the webhook template and restricts its constructs so rendering
+// stays linear in its size: no templates are defined or invoked and ranges
+// only go over the fields of the data, never nested.
+func parseTemplate(text string) (*template.Template, error) {
+	t, err := template.New("webhook").Funcs(payloadFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(t.Templates()) > 1 {
+		return nil, &payloadError{"Template definitions are not allowed"}
+	}
+
+	if err := checkTemplateNode(t.Tree.Root, false); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// checks the constructs of the node and its children
+func checkTemplateNode(node parse.Node, inRange bool) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+
+		for _, child := range n.Nodes {
+			if err := checkTemplateNode(child, inRange); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return checkTemplateBranch(&n.BranchNode, inRange)
+	case *parse.WithNode:
+		return checkTemplateBranch(&n.BranchNode, inRange)
+	case *parse.RangeNode:
+		if inRange {
+			return &payloadError{"Nested ranges are not allowed in templates"}
+		}
+
+		if !rangesOverData(n.Pipe) {
+			return &payloadError{fmt.Sprintf("Template ranges over other than the data: %s", n.Pipe)}
+		}
+
+		if err := checkTemplateNode(n.List, true); err != nil {
+			return err
+		}
+
+		return checkTemplateNode(n.ElseList, false)
+	case *parse.TemplateNode:
+		return &payloadError{"Template invocations are not allowed"}
+	}
+
+	return nil
+}
+
+// checks the lists of an if or with
+func checkTemplateBranch(n *parse.BranchNode, inRange bool) error {
+	if err := checkTemplateNode(n.List, inRange); err != nil {
+		return err
+	}
+
+	return checkTemplateNode(n.ElseList, inRange)
+}
+
+// returns true if the range pipeline is a field of the data, eg. .Rates or
+// $.Rate, rather than a number or the result of a function
+func rangesOverData(pipe *parse.PipeNode) bool {
+	if len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.FieldNode:
+		return true
+	case *parse.VariableNode:
+		return len(arg.Ident) > 1 && arg.Ident[0] == "$"
+	}
+
+	return false
+}
+
+// Validates the payload settings of the webhook: the format, the CloudEvents
+// mode, the symbols and the template, which is rendered once with the
+// current rates. A template without a format selects the template format.
+func (s *Server) checkPayload(hook *webhook) error {
+	if hook.Format == "" && hook.Template != "" {
+		hook.Format = payloadTemplate
+	}
+
+	switch hook.Format {
+	case "", payloadJson, payloadCsv, payloadForm:
+		if hook.Template != "" {
+			return &payloadError{fmt.Sprintf("Template given for format: %s", hook.Format)}
+		}
+	case payloadTemplate:
+		if hook.Template == "" {
+			return &payloadError{"Template format without template"}
+		}
+
+		if len(hook.Template) > maxTemplateSize {
+			return &payloadError{fmt.Sprintf("Template larger than %d bytes", maxTemplateSize)}
+		}
+	default:
+		return &payloadError{fmt.Sprintf("Unknown payload format: %s", hook.Format)}
+	}
+
+	if err := checkCloudEventsMode(hook.CloudEvents); err != nil {
+		return &payloadError{err.Error()}
+	}
+
+	s.rateMutex.RLock()
+	for _, symbol := range hook.Symbols {
+		if _, found := s.currencies[symbol]; !found {
+			s.rateMutex.RUnlock()
+			return &payloadError{fmt.Sprintf("Unknown symbol: %s", symbol)}
+		}
+	}
+	s.rateMutex.RUnlock()
+
+	if _, _, err := s.webhookPayload(*hook); err != nil {
+		return &payloadError{err.Error()}
+	}
+
+	return nil
+}
+
+// Creates the payload of the webhook from the current rates in the base
+// currency of the webhook, limited to its symbols. Returns the payload and
+// its content type.
+func (s *Server) webhookPayload(hook webhook) (payload []byte, contentType string, err error) {
+	res, err := s.createResponse(hook.BaseCurrency)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// keep the rates of the symbols, sorted by name
+	if len(hook.Symbols) > 0 {
+		rates := []rateResponse{}
+		for _, rate := range res.Rates {
+			if contains(hook.Symbols, rate.Name) {
+				rates = append(rates, rate)
+			}
+		}
+		res.Rates = rates
+	}
+	sort.Slice(res.Rates, func(i, j int) bool { return res.Rates[i].Name < res.Rates[j].Name })
+
+	b := &bytes.Buffer{}
+	switch hook.Format {
+	case payloadCsv:
+		w := csv.NewWriter(b)
+		w.WriteAll(res.csvRecords())
+		return b.Bytes(), formatContentTypes[formatCsv], w.Error()
+	case payloadForm:
+		form := url.Values{}
+		form.Set("currency_date", res.CurrencyDate)
+		form.Set("base_currency", res.BaseCurrency)
+		form.Set("rate_version", res.RateVersion)
+		form.Set("provider", res.Provider)
+		for _, rate := range res.Rates {
+			form.Set("rates["+rate.Name+"]", formatCsvFloat(rate.Rate))
+		}
+		return []byte(form.Encode()), "application/x-www-form-urlencoded", nil
+	case payloadTemplate:
+		t, err := parseTemplate(hook.Template)
+		if err != nil {
+			return nil, "", err
+		}
+
+		data := payloadData{
+			Date:     res.CurrencyDate,
+			Base:     res.BaseCurrency,
+			Version:  res.RateVersion,
+			Provider: res.Provider,
+			Rates:    res.Rates,
+			Rate:     make(map[string]float64),
+		}
+		for _, rate := range res.Rates {
+			data.Rate[rate.Name] = rate.Rate
+		}
+
+		err = t.Execute(&limitedWriter{w: b, left: maxPayloadSize}, data)
+		if err != nil {
+			return nil, "", err
+		}
+
+		contentType = hook.ContentType
+		if contentType == "" {
+			contentType = defaultTemplateContent
+		}
+		return b.Bytes(), contentType, nil
+	default:
+		err = json.NewEncoder(b).Encode(res)
+		return b.Bytes(), "application/json", err
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWebhookPayloadFormats(t *testing.T) {
+	symbols := []string{"USD", "DKK"}
+
+	payload, contentType, err := server.webhookPayload(webhook{BaseCurrency: eur, Symbols: symbols})
+	var res currencyResponse
+	if err != nil || contentType != "application/json" || json.Unmarshal(payload, &res) != nil || len(res.Rates) != 2 || res.Rates[0].Name != "DKK" {
+		t.Fatal("Unexpected JSON payload:", string(payload), err)
+	}
+
+	payload, contentType, err = server.webhookPayload(webhook{BaseCurrency: eur, Symbols: symbols, Format: payloadCsv})
+	records, _ := csv.NewReader(strings.NewReader(string(payload))).ReadAll()
+	if err != nil || contentType != "text/csv; charset=utf-8" || len(records) != 3 || records[2][4] != "USD" {
+		t.Fatal("Unexpected CSV payload:", string(payload), err)
+	}
+
+	payload, contentType, err = server.webhookPayload(webhook{BaseCurrency: "USD", Symbols: symbols, Format: payloadForm})
+	form, _ := url.ParseQuery(string(payload))
+	if err != nil || contentType != "application/x-www-form-urlencoded" || form.Get("base_currency") != "USD" || form.Get("rates[USD]") != "1" || form.Get("rates[GBP]") != "" {
+		t.Fatal("Unexpected form payload:", string(payload), err)
+	}
+}
+
+func TestWebhookPayloadTemplate(t *testing.T) {
+	hook := webhook{
+		BaseCurrency: eur,
+		Symbols:      []string{"USD", "DKK"},
+		Template:     `{"text": {{ json (printf "%s: %s" .Date (format .Rate.USD "USD" "en")) }}, "count": {{ len .Rates }}}`,
+		ContentType:  "application/json",
+	}
+
+	if err := server.checkPayload(&hook); err != nil || hook.Format != payloadTemplate {
+		t.Fatal("Expected a valid template:", err)
+	}
+
+	payload, contentType, err := server.webhookPayload(hook)
+	var msg struct {
+		Text  string `json:"text"`
+		Count int    `json:"count"`
+	}
+	if err != nil || contentType != "application/json" || json.Unmarshal(payload, &msg) != nil {
+		t.Fatal("Unexpected template payload:", string(payload), err)
+	}
+
+	if msg.Count != 2 || !strings.HasPrefix(msg.Text, server.lastUpdateTime.Format(currencyDateFormat)+": $") {
+		t.Fatal("Unexpected message:", msg)
+	}
+}
+
+func TestWebhookPayloadRange(t *testing.T) {
+	hook := webhook{BaseCurrency: eur, Symbols: []string{"USD", "DKK"}, Template: `{{ range $name, $rate := .Rate }}{{ $name }}={{ $rate }};{{ end }}{{ range .Rates }}{{ if eq .Name "USD" }}{{ $.Base }}{{ end }}{{ end }}`}
+	if err := server.checkPayload(&hook); err != nil {
+		t.Fatal("Expected a valid template:", err)
+	}
+
+	payload, _, _ := server.webhookPayload(hook)
+	if !strings.HasPrefix(string(payload), "DKK=") || !strings.HasSuffix(string(payload), ";EUR") {
+		t.Fatal("Unexpected template payload:", string(payload))
+	}
+}
+
+func TestCheckPayload(t *testing.T) {
+	hooks := []webhook{
+		{BaseCurrency: eur, Format: "yaml"},
+		{BaseCurrency: eur, Format: payloadTemplate},
+		{BaseCurrency: eur, Format: payloadCsv, Template: "{{ .Date }}"},
+		{BaseCurrency: eur, Symbols: []string{"FOO"}},
+		{BaseCurrency: eur, Template: "{{ .Date "},
+		{BaseCurrency: eur, Template: "{{ .Unknown }}"},
+		{BaseCurrency: eur, Template: "{{ .Rate.FOO }}"},
+		{BaseCurrency: eur, Template: strings.Repeat("x", maxTemplateSize+1)},
+		{BaseCurrency: eur, Template: "{{ range .Rates }}{{ range $.Rates }}{{ end }}{{ end }}"},
+		{BaseCurrency: eur, Template: "{{ range .Rates }}{{ with . }}{{ range $.Rate }}{{ end }}{{ end }}{{ end }}"},
+		{BaseCurrency: eur, Template: "{{ range 1000000000 }}{{ end }}"},
+		{BaseCurrency: eur, Template: "{{ range (slice .Rates 1) }}{{ end }}"},
+		{BaseCurrency: eur, Template: `{{ define "x" }}{{ template "x" }}{{ end }}{{ template "x" }}`},
+		{BaseCurrency: eur, Template: `{{ block "x" . }}{{ end }}`},
+		{BaseCurrency: eur, Template: strings.Repeat(`{{ printf "%999999d" 1 }}`, 2)},
+	}
+
+	for _, hook := range hooks {
+		if err := server.checkPayload(&hook); !rejectedWebhook(err) {
+			t.Fatal("Expected the payload to be rejected:", hook.Format, hook.Template, err)
+		}
+	}
+}
+
+func TestWebhookTemplateDelivery(t *testing.T) {
+	var contentType, body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(r.URL.Query().Get("hub.challenge")))
+			return
+		}
+
+		data, _ := ioutil.ReadAll(r.Body)
+		contentType, body = r.Header.Get("Content-Type"), string(data)
+	}))
+	defer ts.Close()
+	defer func() {
+		server.mutex.Lock()
+		delete(server.webhooks, ts.URL)
+		server.mutex.Unlock()
+	}()
+
+	r := fireReq("/webhook", http.MethodPost, webhook{
+		BaseCurrency: "DKK",
+		Url:          ts.URL,
+		Symbols:      []string{"USD"},
+		Template:     "1 DKK = {{ printf \"%.4f\" .Rate.USD }} USD",
+	})
+	expect(t, r, http.StatusOK, true, nil)
+
+	if contentType != defaultTemplateContent || !strings.HasPrefix(body, "1 DKK = 0.") {
+		t.Fatal("Unexpected delivery:", contentType, body)
+	}
+
+	r = fireReq("/webhook", http.MethodPost, webhook{BaseCurrency: "DKK", Url: ts.URL, Template: "{{ .Nope }}"})
+	expect(t, r, http.StatusBadRequest, true, nil)
+}
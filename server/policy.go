@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWebhookSchemes = "http,https" // default allowed schemes of webhook URLs
+	defaultWebhookTimeout = "10s"        // default timeout of a webhook call
+
+	// timeout of the notifications and of policies without a configured one
+	webhookTimeout = 10 * time.Second
+)
+
+// the ranges webhooks may not call unless allowed explicitly, on top of the
+// loopback, private, link-local, multicast and unspecified addresses
+var reservedNetworks = parseNetworks(
+	"0.0.0.0/8",       // this network
+	"100.64.0.0/10",   // shared address space (carrier-grade NAT)
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"240.0.0.0/4",     // reserved
+	"64:ff9b::/96",    // NAT64, may map to private IPv4
+	"2001:db8::/32",   // documentation
+)
+
+// An error caused by the webhook URL policy, the URL is rejected rather
+// than failing.
+type policyError struct {
+	reason string
+}
+
+func (e *policyError) Error() string {
+	return e.reason
+}
+
+// The policy of the URLs the server calls. Hosts and addresses in the deny
+// list are always rejected, those in the allow list skip the private
+// address checks. List entries are host names ("hooks.example.com"),
+// wildcards ("*.example.com"), addresses or CIDR ranges ("10.1.0.0/16").
+type urlPolicy struct {
+	schemes []string
+	allow   []string
+	deny    []string
+	timeout time.Duration // timeout of the calls and their lookups
+}
+
+// parses the comma separated lists of the policy
+func newUrlPolicy(schemes, allow, deny string) (p *urlPolicy, err error) {
+	p = &urlPolicy{schemes: splitList(strings.ToLower(schemes)), allow: splitList(allow), deny: splitList(deny), timeout: webhookTimeout}
+	for _, entry := range append(p.allow, p.deny...) {
+		if strings.Contains(entry, "/") {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return nil, fmt.Errorf("Invalid CIDR range: %s", entry)
+			}
+		}
+	}
+
+	if len(p.schemes) == 0 {
+		return nil, fmt.Errorf("No webhook schemes allowed")
+	}
+
+	return p, nil
+}
+
+// splits a comma separated list, dropping empty entries
+func splitList(list string) (result []string) {
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			result = append(result, entry)
+		}
+	}
+
+	return result
+}
+
+// parses the CIDR ranges, panics on invalid ranges
+func parseNetworks(cidrs ...string) (networks []*net.IPNet) {
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks
+}
+
+// returns true if a list entry matches the host or the address
+func listMatches(list []string, host string, ip net.IP) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, entry := range list {
+		entry = strings.ToLower(entry)
+		switch {
+		case strings.Contains(entry, "/"):
+			_, network, _ := net.ParseCIDR(entry)
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+		case net.ParseIP(entry) != nil:
+			if ip != nil && net.ParseIP(entry).Equal(ip) {
+				return true
+			}
+		case strings.HasPrefix(entry, "*."):
+			if strings.HasSuffix(host, entry[1:]) {
+				return true
+			}
+		case entry == host:
+			return true
+		}
+	}
+
+	return false
+}
+
+// returns true if the address is internal: loopback, private, link-local,
+// multicast, unspecified or reserved
+func internalAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checks a resolved address of the host against the lists and the internal
+// ranges
+func (p *urlPolicy) checkAddress(host string, ip net.IP) error {
+	if listMatches(p.deny, host, ip) {
+		return &policyError{fmt.Sprintf("Webhook address denied: %s (%s)", host, ip)}
+	}
+
+	if listMatches(p.allow, host, ip) {
+		return nil
+	}
+
+	if internalAddress(ip) {
+		return &policyError{fmt.Sprintf("Webhook address is internal: %s (%s)", host, ip)}
+	}
+
+	return nil
+}
+
+// Resolves the host and checks every address. Fails if any address is
+// rejected so a host can't hide an internal address among public ones.
+func (p *urlPolicy) resolve(ctx context.Context, host string) (ips []net.IP, err error) {
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, &policyError{fmt.Sprintf("Error resolving webhook host: %s", err)}
+		}
+
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	for _, ip := range ips {
+		if err = p.checkAddress(host, ip); err != nil {
+			return nil, err
+		}
+	}
+
+	return ips, nil
+}
+
+// Checks the URL against the policy: the scheme, the host lists and the
+// resolved addresses of the host.
+func (p *urlPolicy) checkUrl(rawUrl string) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return &policyError{fmt.Sprintf("Invalid webhook URL: %s", err)}
+	}
+
+	if !contains(p.schemes, strings.ToLower(u.Scheme)) {
+		return &policyError{fmt.Sprintf("Webhook scheme not allowed: %s", u.Scheme)}
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return &policyError{"Webhook URL without host"}
+	}
+
+	if listMatches(p.deny, host, nil) {
+		return &policyError{fmt.Sprintf("Webhook host denied: %s", host)}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	_, err = p.resolve(ctx, host)
+	return err
+}
+
+// Dials the address after checking the resolved addresses of its host, the
+// checked address is dialed so the host can't resolve differently in between.
+func (p *urlPolicy) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := p.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: p.timeout}
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+// Returns the client for calling webhooks. Every connection is checked
+// against the policy, redirects are not followed and no proxy is used.
+func (p *urlPolicy) client() *http.Client {
+	return &http.Client{
+		Timeout: p.timeout,
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         p.dialContext,
+			TLSHandshakeTimeout: p.timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
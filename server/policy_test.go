@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInternalAddress(t *testing.T) {
+	addresses := map[string]bool{
+		"127.0.0.1":       true,
+		"10.1.2.3":        true,
+		"172.16.0.1":      true,
+		"192.168.1.1":     true,
+		"169.254.169.254": true,
+		"100.64.0.1":      true,
+		"0.0.0.0":         true,
+		"::1":             true,
+		"fe80::1":         true,
+		"fd00::1":         true,
+		"::ffff:10.0.0.1": true,
+		"93.184.216.34":   false,
+		"2606:4700::1111": false,
+	}
+
+	for address, internal := range addresses {
+		if internalAddress(net.ParseIP(address)) != internal {
+			t.Fatal("Unexpected internal address:", address)
+		}
+	}
+}
+
+func TestCheckUrl(t *testing.T) {
+	p, err := newUrlPolicy("https", "10.1.0.0/16, *.internal.example", "93.184.216.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	urls := map[string]bool{
+		"https://10.1.2.3/hook":          true,
+		"https://[2606:4700::1111]/":     true,
+		"http://10.1.2.3/hook":           false, // scheme
+		"ftp://10.1.2.3/hook":            false,
+		"https://10.2.0.1/hook":          false, // private
+		"https://169.254.169.254/":       false, // metadata
+		"https://[::1]:8080/":            false,
+		"https://localhost/":             false, // resolves to loopback
+		"https://93.184.216.34/":         false, // denied
+		"https://hooks.internal.example": false,
+		"https:///hook":                  false,
+	}
+
+	for u, ok := range urls {
+		err := p.checkUrl(u)
+		if (err == nil) != ok {
+			t.Fatal("Unexpected result:", u, err)
+		}
+	}
+
+	if _, err := newUrlPolicy("https", "10.0.0.0/33", ""); err == nil {
+		t.Fatal("Expected an error for an invalid range")
+	}
+}
+
+func TestListMatches(t *testing.T) {
+	list := []string{"hooks.example.com", "*.internal.example", "10.0.0.0/8", "192.168.1.1"}
+	cases := []struct {
+		host  string
+		ip    string
+		match bool
+	}{
+		{"HOOKS.example.com.", "", true},
+		{"a.b.internal.example", "", true},
+		{"internal.example", "", false},
+		{"other", "10.9.8.7", true},
+		{"other", "192.168.1.1", true},
+		{"other", "192.168.1.2", false},
+	}
+
+	for _, c := range cases {
+		if listMatches(list, c.host, net.ParseIP(c.ip)) != c.match {
+			t.Fatal("Unexpected match:", c)
+		}
+	}
+}
+
+func TestPolicyClient(t *testing.T) {
+	target := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/target", http.StatusFound)
+			return
+		}
+
+		target++
+	}))
+	defer ts.Close()
+
+	// connections to loopback are rejected when dialing
+	p, _ := newUrlPolicy(defaultWebhookSchemes, "", "")
+	if _, err := p.client().Get(ts.URL); err == nil {
+		t.Fatal("Expected the loopback connection to be rejected")
+	}
+
+	// redirects are not followed
+	p, _ = newUrlPolicy(defaultWebhookSchemes, "127.0.0.1", "")
+	res, err := p.client().Get(ts.URL + "/redirect")
+	if err != nil || res.StatusCode != http.StatusFound || target != 0 {
+		t.Fatal("Expected the redirect to be returned:", err, target)
+	}
+}
+
+func TestWebhookPolicy(t *testing.T) {
+	r := fireReq("/webhook", http.MethodPost, webhook{
+		BaseCurrency: "DKK",
+		Secret:       "verysecret",
+		Url:          "http://169.254.169.254/latest/meta-data",
+	})
+	expect(t, r, http.StatusBadRequest, true, nil)
+
+	r = fireReq("/webhook", http.MethodPost, webhook{
+		BaseCurrency: "DKK",
+		Secret:       "verysecret",
+		Url:          "file:///etc/passwd",
+	})
+	expect(t, r, http.StatusBadRequest, true, nil)
+}
+
+func TestWebhookPolicyOnDelivery(t *testing.T) {
+	defer func(p *urlPolicy) { server.webhookPolicy = p }(server.webhookPolicy)
+
+	hook := webhook{BaseCurrency: "DKK", Secret: "verysecret", Url: "http://" + webhookServerAddr}
+	if err := server.verifyWebhook(&hook); err != nil {
+		t.Fatal(err)
+	}
+
+	count := hookServer.calls
+	server.webhookPolicy, _ = newUrlPolicy(defaultWebhookSchemes, "127.0.0.1", "127.0.0.1")
+	if err := server.callSingleWebhook(context.Background(), hook); err == nil || hookServer.calls != count {
+		t.Fatal("Expected the delivery to be rejected")
+	}
+}
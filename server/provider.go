@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	frankfurterCurrencyUrl = "https://api.frankfurter.app/latest"
+
+	defaultProviders  = "ecb"  // only the ECB unless configured otherwise
+	defaultStaleAfter = "144h" // covers weekends and the longest TARGET holiday gap
+)
+
+// A source of currency rates. The parse function must return the rates
+// relative to EUR like the ECB data.
+type provider struct {
+	name  string
+	url   string
+	parse func(data []byte) (ts time.Time, currencies map[string]float64, err error)
+}
+
+// the known provider formats and their default URLs
+var knownProviders = map[string]provider{
+	"ecb":         {name: "ecb", url: ecbCurrencyUrl, parse: parseCurrencyData},
+	"frankfurter": {name: "frankfurter", url: frankfurterCurrencyUrl, parse: parseFrankfurterData},
+}
+
+// A set of rates as fetched from a single provider.
+type rateSet struct {
+	provider   string
+	priority   int // index in the provider list
+	time       time.Time
+	currencies map[string]float64
+	mismatches map[string][]rateMismatch
+}
+
+// struct for a currency where another provider disagrees with the rate used
+type rateMismatch struct {
+	Provider  string  `json:"provider" xml:"provider,attr"`
+	Deviation float64 `json:"deviation" xml:"deviation,attr"` // in percent
+}
+
+// Parses an ordered, comma separated list of providers. Every entry is the
+// name of a known provider optionally followed by "=" and the URL to fetch
+// the data from, eg. "ecb,frankfurter=https://mirror.example.com/latest".
+func parseProviders(list string) (providers []provider, err error) {
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, url := entry, ""
+		if i := strings.Index(entry, "="); i >= 0 {
+			name, url = entry[:i], entry[i+1:]
+		}
+
+		p, found := knownProviders[name]
+		if !found {
+			return nil, fmt.Errorf("Unknown provider: %s", name)
+		}
+
+		if url != "" {
+			p.url = url
+		}
+
+		providers = append(providers, p)
+	}
+
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("No providers configured")
+	}
+
+	return providers, nil
+}
+
+// Fetches and parses the rates from the provider.
+func (p provider) fetch(ctx context.Context) (set *rateSet, err error) {
+	ctx, span := startSpan(ctx, "provider.fetch", spanInternal)
+	span.set("provider", p.name)
+	defer func() { span.end(err) }()
+
+	data, err := fetchCurrencyData(ctx, p.url)
+	if err != nil {
+		return nil, err
+	}
+
+	_, parseSpan := startSpan(ctx, "rates.parse", spanInternal)
+	ts, currencies, err := p.parse(data)
+	parseSpan.set("rates.count", len(currencies))
+	parseSpan.end(err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rateSet{provider: p.name, time: ts, currencies: currencies}, nil
+}
+
+// Fetches the rates from the providers in order. The first provider with
+// fresh rates is used. If every provider is stale the newest rates are used
+// and if every provider fails the last error is returned. When a tolerance
+// is set the remaining providers are fetched as well to cross-check the
+// rates.
+func (s *Server) fetchRates(ctx context.Context) (set *rateSet, err error) {
+	var fetched []*rateSet
+	for i, p := range s.providers {
+		// stop at the first fresh set unless cross-checking
+		if set != nil && s.tolerance <= 0 {
+			break
+		}
+
+		start := time.Now()
+		fetchCtx, cancel := context.WithTimeout(ctx, s.fetchTimeout)
+		res, fetchErr := p.fetch(fetchCtx)
+		cancel()
+		s.metrics.observeFetch(p.name, fetchErr, time.Since(start))
+		if fetchErr != nil {
+			s.logger.Warn("Error fetching rates", "component", "updater", "provider", p.name, "error", fetchErr)
+			err = fetchErr
+			continue
+		}
+
+		res.priority = i
+		fetched = append(fetched, res)
+
+		if time.Since(res.time) > s.staleAfter {
+			s.logger.Warn("Stale rates", "component", "updater", "provider", p.name, "rate_date", res.time.Format(currencyDateFormat))
+			continue
+		}
+
+		if set == nil {
+			set = res
+		}
+	}
+
+	if len(fetched) == 0 {
+		return nil, err
+	}
+
+	// everything is stale - use the newest rates
+	if set == nil {
+		set = fetched[0]
+		for _, res := range fetched[1:] {
+			if res.time.After(set.time) {
+				set = res
+			}
+		}
+	}
+
+	if set.priority != 0 {
+		s.providerFailovers.Add(1)
+	}
+
+	if s.tolerance > 0 {
+		set.mismatches = crossCheck(set, fetched, s.tolerance)
+	}
+
+	return set, nil
+}
+
+// Compares the rates of the used set with the other fetched sets and returns
+// the currencies where a rate deviates more than tolerance percent. Rates
+// that aren't positive have no deviation and are skipped.
+func crossCheck(used *rateSet, fetched []*rateSet, tolerance float64) (mismatches map[string][]rateMismatch) {
+	mismatches = make(map[string][]rateMismatch)
+	for _, other := range fetched {
+		if other == used {
+			continue
+		}
+
+		for name, rate := range used.currencies {
+			otherRate, found := other.currencies[name]
+			if !found || rate <= 0 || otherRate <= 0 {
+				continue
+			}
+
+			deviation := math.Abs(otherRate-rate) / rate * 100
+			if deviation > tolerance {
+				mismatches[name] = append(mismatches[name], rateMismatch{
+					Provider:  other.provider,
+					Deviation: deviation,
+				})
+			}
+		}
+	}
+
+	return mismatches
+}
+
+// the frankfurter JSON structure
+type frankfurterEnvelope struct {
+	Base  string             `json:"base"`
+	Date  string             `json:"date"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+// Parse the JSON data from a frankfurter compatible API. The rates are
+// converted to be relative to EUR if the data has another base.
+func parseFrankfurterData(data []byte) (ts time.Time, currencies map[string]float64, err error) {
+	var f frankfurterEnvelope
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	ts, err = time.Parse(currencyDateFormat, f.Date)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	currencies = make(map[string]float64)
+	currencies[f.Base] = 1
+	for name, rate := range f.Rates {
+		currencies[name] = rate
+	}
+
+	// rebase to EUR if needed
+	eurRate, found := currencies[eur]
+	if !found || eurRate == 0 {
+		return time.Time{}, nil, fmt.Errorf("No EUR rate in data")
+	}
+
+	for name, rate := range currencies {
+		currencies[name] = rate / eurRate
+	}
+
+	return ts, currencies, nil
+}
+
+// returns the mismatching currencies among the given names, sorted, must
+// be called while holding the rate lock
+func (s *Server) mismatching(names ...string) (result []string) {
+	for _, name := range names {
+		if _, found := s.mismatches[name]; found && !contains(result, name) {
+			result = append(result, name)
+		}
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// returns true if the slice contains the given string
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
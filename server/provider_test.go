@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseProviders(t *testing.T) {
+	providers, err := parseProviders("ecb, frankfurter=http://example.com/latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(providers) != 2 {
+		t.Fatal("Expected two providers, got:", len(providers))
+	}
+
+	if providers[0].url != ecbCurrencyUrl {
+		t.Fatal("Unexpected default URL:", providers[0].url)
+	}
+
+	if providers[1].url != "http://example.com/latest" {
+		t.Fatal("Unexpected custom URL:", providers[1].url)
+	}
+
+	if _, err := parseProviders("ecb,foo"); err == nil {
+		t.Fatal("Provider shouldn't be known: foo")
+	}
+
+	if _, err := parseProviders(" , "); err == nil {
+		t.Fatal("Expected error on empty provider list")
+	}
+}
+
+func TestParseFrankfurterData(t *testing.T) {
+	data := []byte(`{"amount":1.0,"base":"USD","date":"2016-04-01","rates":{"EUR":0.5,"DKK":3.5}}`)
+	ts, currencies, err := parseFrankfurterData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ts.Format(currencyDateFormat) != "2016-04-01" {
+		t.Fatal("Unexpected date:", ts)
+	}
+
+	if currencies[eur] != 1 || currencies["USD"] != 2 || currencies["DKK"] != 7 {
+		t.Fatal("Unexpected rates:", currencies)
+	}
+
+	if _, _, err := parseFrankfurterData([]byte(`{"base":"USD","date":"2016-04-01","rates":{}}`)); err == nil {
+		t.Fatal("Expected error without EUR rate")
+	}
+}
+
+func TestProviderFailover(t *testing.T) {
+	failing := httptest.NewServer(http.NotFoundHandler())
+	defer failing.Close()
+	stale := frankfurterServer("2001-01-01", 7.0)
+	defer stale.Close()
+	fresh := frankfurterServer(time.Now().Format(currencyDateFormat), 7.5)
+	defer fresh.Close()
+
+	s := providerServer(0, failing.URL, stale.URL, fresh.URL)
+	set, err := s.fetchRates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if set.currencies["DKK"] != 7.5 {
+		t.Fatal("Expected the fresh rates, got:", set.currencies["DKK"])
+	}
+
+	if s.providerFailovers.Value() != 1 {
+		t.Fatal("Expected a failover to be counted")
+	}
+
+	// only stale rates, the newest are used
+	s = providerServer(0, failing.URL, stale.URL)
+	set, err = s.fetchRates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if set.currencies["DKK"] != 7.0 {
+		t.Fatal("Expected the stale rates, got:", set.currencies["DKK"])
+	}
+
+	// nothing works
+	s = providerServer(0, failing.URL)
+	if _, err = s.fetchRates(context.Background()); err == nil {
+		t.Fatal("Expected error when every provider fails")
+	}
+}
+
+func TestProviderCrossCheck(t *testing.T) {
+	today := time.Now().Format(currencyDateFormat)
+	first := frankfurterServer(today, 7.5)
+	defer first.Close()
+	close := frankfurterServer(today, 7.501)
+	defer close.Close()
+	far := frankfurterServer(today, 8)
+	defer far.Close()
+
+	s := providerServer(0.5, first.URL, close.URL)
+	set, err := s.fetchRates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(set.mismatches) != 0 {
+		t.Fatal("Expected no mismatches:", set.mismatches)
+	}
+
+	s = providerServer(0.5, first.URL, close.URL, far.URL)
+	set, err = s.fetchRates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(set.mismatches["DKK"]) != 1 || set.mismatches["DKK"][0].Deviation < 6 {
+		t.Fatal("Expected DKK mismatch, got:", set.mismatches)
+	}
+}
+
+func TestCrossCheckInvalidRates(t *testing.T) {
+	used := &rateSet{provider: "first", currencies: map[string]float64{"USD": 0, "DKK": 7.5, "SEK": 10}}
+	other := &rateSet{provider: "second", currencies: map[string]float64{"USD": 1.1, "DKK": -7.5, "SEK": 11}}
+
+	mismatches := crossCheck(used, []*rateSet{used, other}, 0.5)
+	if len(mismatches) != 1 || len(mismatches["SEK"]) != 1 {
+		t.Fatal("Expected the SEK mismatch only:", mismatches)
+	}
+}
+
+func TestCurrencyResponseProvider(t *testing.T) {
+	res, err := server.createResponse(eur)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Provider == "" {
+		t.Fatal("Expected the provider in the response")
+	}
+}
+
+// creates a server fetching from frankfurter compatible providers at the
+// given URLs
+func providerServer(tolerance float64, urls ...string) *Server {
+	s := &Server{
+		staleAfter:        time.Hour * 144,
+		tolerance:         tolerance,
+		providerFailovers: new(expvar.Int),
+		metrics:           newMetrics(),
+		fetchTimeout:      time.Minute,
+		logger:            slog.Default(),
+	}
+
+	for _, url := range urls {
+		p := knownProviders["frankfurter"]
+		p.url = url
+		s.providers = append(s.providers, p)
+	}
+
+	return s
+}
+
+// starts a provider serving frankfurter data with the given date and DKK rate
+func frankfurterServer(date string, dkk float64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"base":"EUR","date":"%s","rates":{"DKK":%f,"USD":1.1}}`, date, dkk)
+	}))
+}
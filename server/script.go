@@ -1,10 +1,52 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
+	"sort"
 	"text/template"
 )
 
+// the data embedded in the script, marshalled as JSON so every value is
+// safely escaped
+type scriptData struct {
+	Date       string             `json:"date"`
+	Base       string             `json:"base"`
+	Version    string             `json:"version"`
+	Currencies []string           `json:"currencies"`
+	Rates      map[string]float64 `json:"rates"`
+	MinorUnits map[string]int     `json:"minor_units"`
+}
+
+// Creates the script data from a currency response. The currencies are
+// sorted and every currency has its minor units.
+func createScriptData(res *currencyResponse) (data scriptData) {
+	data = scriptData{
+		Date:       res.CurrencyDate,
+		Base:       res.BaseCurrency,
+		Version:    res.RateVersion,
+		Currencies: []string{},
+		Rates:      make(map[string]float64),
+		MinorUnits: make(map[string]int),
+	}
+
+	for _, rate := range res.Rates {
+		data.Currencies = append(data.Currencies, rate.Name)
+		data.Rates[rate.Name] = rate.Rate
+
+		data.MinorUnits[rate.Name] = defaultMinorUnits
+		if info, found := catalogue[rate.Name]; found {
+			data.MinorUnits[rate.Name] = info.MinorUnits
+		}
+	}
+
+	sort.Strings(data.Currencies)
+	return data
+}
+
+// Handles the script requests (/script). The script defines window.currency
+// unless the ES module variant is requested with module=true.
 func (s *Server) scriptHandler(w http.ResponseWriter, r *http.Request) {
 	base := r.URL.Query().Get("base")
 	res, err := s.createResponse(base)
@@ -13,35 +55,102 @@ func (s *Server) scriptHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	t, err := template.New("script").Parse(scriptTemplate)
+	data, err := json.Marshal(createScriptData(res))
 	if err != nil {
-		http.Error(w, "Error parsing template", http.StatusInternalServerError)
+		http.Error(w, "Error creating response", http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Add("Content-Type", "text/javascript")
-	err = t.Execute(w, res)
+	variant := "global"
+	if r.URL.Query().Get("module") == "true" {
+		variant = "module"
+	}
+
+	if s.cacheResponse(w, r, base+"-"+variant+".js") {
+		return
+	}
+
+	// render before writing so errors can still be reported
+	b := &bytes.Buffer{}
+	err = scriptTemplate.ExecuteTemplate(b, variant, string(data))
 	if err != nil {
-		http.Error(w, "Error parsing template", http.StatusInternalServerError)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
 	}
+
+	w.Header().Add("Content-Type", "text/javascript; charset=utf-8")
+	w.Write(b.Bytes())
 }
 
-var scriptTemplate = `
-(function() {
-	var currency = {
-		currencies: [{{ range .Rates }}'{{.Name}}',{{end}}],
+// the script template, parsed once
+var scriptTemplate = template.Must(template.New("script").Parse(scriptSource))
+
+var scriptSource = `
+{{- define "currency" -}}
+{
+	date: data.date,
+	base: data.base,
+	version: data.version,
+	currencies: data.currencies,
+	rates: data.rates,
+	minorUnits: data.minor_units,
 
-		rates: {
-			{{ range .Rates }}
-			'{{.Name}}': {{.Rate}},
-			{{ end }}
-		},
+	// rounds half away from zero
+	round: function(amount, decimals) {
+		var scale = Math.pow(10, decimals);
+		return (amount < 0 ? -1 : 1) * Math.round(Math.abs(amount) * scale) / scale;
+	},
 
-		convert: function(amount, target) {
-			return this.rates[target] * amount
+	// converts the amount from one currency to another, rounded to the
+	// minor units of the target unless decimals is given (null disables
+	// rounding). convert(amount, target) converts from the base.
+	convert: function(amount, from, to, decimals) {
+		if (to === undefined) {
+			to = from;
+			from = this.base;
 		}
+
+		if (!(from in this.rates)) {
+			throw new Error('Unknown currency: ' + from);
+		}
+
+		if (!(to in this.rates)) {
+			throw new Error('Unknown currency: ' + to);
+		}
+
+		var result = amount / this.rates[from] * this.rates[to];
+		if (decimals === undefined) {
+			decimals = this.minorUnits[to];
+		}
+
+		return decimals === null ? result : this.round(result, decimals);
 	}
+}
+{{- end -}}
+
+{{- define "global" -}}
+(function() {
+	var data = {{ . }};
 
-	window.currency = currency
+	var currency = {{ template "currency" }};
+
+	window.currency = currency;
 })();
+{{ end -}}
+
+{{- define "module" -}}
+const data = {{ . }};
+
+const currency = {{ template "currency" }};
+
+export const date = currency.date;
+export const base = currency.base;
+export const currencies = currency.currencies;
+export const rates = currency.rates;
+export function convert(amount, from, to, decimals) {
+	return currency.convert(amount, from, to, decimals);
+}
+
+export default currency;
+{{ end -}}
 `
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestScriptData(t *testing.T) {
+	res, err := server.createResponse("USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := createScriptData(res)
+	if data.Base != "USD" || data.Date != res.CurrencyDate || data.Version != res.RateVersion {
+		t.Fatal("Unexpected script data:", data.Base, data.Date, data.Version)
+	}
+
+	if len(data.Currencies) != len(res.Rates) || data.Rates["USD"] != 1 {
+		t.Fatal("Unexpected rates:", data.Rates)
+	}
+
+	if data.MinorUnits["JPY"] != 0 || data.MinorUnits["DKK"] != 2 {
+		t.Fatal("Unexpected minor units:", data.MinorUnits)
+	}
+}
+
+func TestScriptGlobal(t *testing.T) {
+	r := fireReq("/script?base=DKK", http.MethodGet, nil)
+	expect(t, r, http.StatusOK, true, nil)
+	if r.Header().Get("Content-Type") != "text/javascript; charset=utf-8" {
+		t.Fatal("Unexpected content type:", r.Header().Get("Content-Type"))
+	}
+
+	body := r.Body.String()
+	if !strings.Contains(body, "window.currency = currency;") || strings.Contains(body, "export") {
+		t.Fatal("Expected the global variant:", body)
+	}
+
+	// the embedded data must be the JSON of the script data
+	start := strings.Index(body, "var data = ") + len("var data = ")
+	end := strings.Index(body[start:], ";\n")
+	var data scriptData
+	err := json.Unmarshal([]byte(body[start:start+end]), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.Base != "DKK" || data.Rates["DKK"] != 1 {
+		t.Fatal("Unexpected embedded data:", data)
+	}
+}
+
+func TestScriptModule(t *testing.T) {
+	r := fireReq("/script?base=DKK&module=true", http.MethodGet, nil)
+	expect(t, r, http.StatusOK, true, nil)
+
+	body := r.Body.String()
+	if !strings.Contains(body, "export default currency;") || strings.Contains(body, "window.currency") {
+		t.Fatal("Expected the module variant:", body)
+	}
+}
+
+func TestScriptUnknownBase(t *testing.T) {
+	r := fireReq("/script?base=FOO", http.MethodGet, nil)
+	expect(t, r, http.StatusInternalServerError, true, nil)
+}
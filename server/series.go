@@ -0,0 +1,181 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	intervalDaily   = "daily"
+	intervalWeekly  = "weekly"
+	intervalMonthly = "monthly"
+
+	defaultSeriesFields = "rate"
+)
+
+// the fields a time series point can have, besides the date
+var seriesFields = map[string]func(a *aggregateResponse) interface{}{
+	"rate":    func(a *aggregateResponse) interface{} { return a.Close },
+	"inverse": func(a *aggregateResponse) interface{} { return 1 / a.Close },
+	"open":    func(a *aggregateResponse) interface{} { return a.Open },
+	"close":   func(a *aggregateResponse) interface{} { return a.Close },
+	"min":     func(a *aggregateResponse) interface{} { return a.Min },
+	"max":     func(a *aggregateResponse) interface{} { return a.Max },
+	"average": func(a *aggregateResponse) interface{} { return a.Average },
+	"days":    func(a *aggregateResponse) interface{} { return a.Days },
+}
+
+// struct for the time series of a currency pair
+type seriesResponse struct {
+	BaseCurrency   string                   `json:"base_currency"`
+	TargetCurrency string                   `json:"target_currency"`
+	Start          string                   `json:"start"`
+	End            string                   `json:"end"`
+	Interval       string                   `json:"interval"`
+	Fields         []string                 `json:"fields"`
+	Points         []map[string]interface{} `json:"points"`
+}
+
+// the aggregated rates of a single interval of a time series
+type seriesBucket struct {
+	start     time.Time // the first day of the interval
+	aggregate *aggregateResponse
+}
+
+// Returns the first day of the interval containing the given day. Weeks
+// start on mondays.
+func intervalStart(interval string, day time.Time) (start time.Time, err error) {
+	switch interval {
+	case intervalDaily:
+		return day, nil
+	case intervalWeekly:
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset), nil
+	case intervalMonthly:
+		return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, day.Location()), nil
+	}
+
+	return day, fmt.Errorf("Unknown interval: %s", interval)
+}
+
+// returns an error if the interval or any of the fields are unknown
+func checkSeriesOptions(interval string, fields []string) error {
+	if _, err := intervalStart(interval, time.Time{}); err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		if _, found := seriesFields[field]; !found {
+			return fmt.Errorf("Unknown field: %s", field)
+		}
+	}
+
+	return nil
+}
+
+// Groups the days of a currency pair between start and end by the interval
+// and aggregates the rates of every interval. Intervals where the pair
+// wasn't known are left out, it is an error if none are left.
+func (s *Server) seriesBuckets(to, from string, start, end time.Time, interval string) (buckets []seriesBucket, err error) {
+	// the days are in order
+	for _, day := range s.history.between(start, end) {
+		bucketStart, err := intervalStart(interval, day.date)
+		if err != nil {
+			return nil, err
+		}
+
+		n := len(buckets)
+		if n == 0 || !buckets[n-1].start.Equal(bucketStart) {
+			buckets = append(buckets, seriesBucket{start: bucketStart, aggregate: newAggregate(to, from)})
+			n++
+		}
+
+		buckets[n-1].aggregate.add(day)
+	}
+
+	known := buckets[:0]
+	for _, bucket := range buckets {
+		if bucket.aggregate.Days > 0 {
+			known = append(known, bucket)
+		}
+	}
+
+	if len(known) == 0 {
+		return nil, fmt.Errorf("No rates for %s/%s from %s to %s", from, to, start.Format(currencyDateFormat), end.Format(currencyDateFormat))
+	}
+
+	return known, nil
+}
+
+// Creates the time series of a currency pair between start and end. The
+// days are grouped by the interval, each point is dated by the first day of
+// its interval and has the requested fields of the aggregated rates.
+func (s *Server) createSeries(to, from string, start, end time.Time, interval string, fields []string) (r *seriesResponse, err error) {
+	err = checkSeriesOptions(interval, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets, err := s.seriesBuckets(to, from, start, end, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	response := seriesResponse{
+		BaseCurrency:   from,
+		TargetCurrency: to,
+		Start:          start.Format(currencyDateFormat),
+		End:            end.Format(currencyDateFormat),
+		Interval:       interval,
+		Fields:         fields,
+		Points:         []map[string]interface{}{},
+	}
+
+	for _, bucket := range buckets {
+		point := map[string]interface{}{"date": bucket.start.Format(currencyDateFormat)}
+		for _, field := range fields {
+			point[field] = seriesFields[field](bucket.aggregate)
+		}
+
+		response.Points = append(response.Points, point)
+	}
+
+	return &response, nil
+}
+
+// Handles the time series requests (/timeseries)
+func (s *Server) seriesHandler(w http.ResponseWriter, r *http.Request) {
+	// only handle GET, error on everything else
+	if r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	start, end, err := parseRange(q.Get("start"), q.Get("end"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	interval := q.Get("interval")
+	if interval == "" {
+		interval = intervalDaily
+	}
+
+	fieldList := q.Get("fields")
+	if fieldList == "" {
+		fieldList = defaultSeriesFields
+	}
+
+	fields := strings.Split(fieldList, ",")
+	if err := checkSeriesOptions(interval, fields); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.createSeries(q.Get("to"), q.Get("from"), start, end, interval, fields)
+	s.respondJson(w, res, err)
+}
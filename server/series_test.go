@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIntervalStart(t *testing.T) {
+	day, _ := time.Parse(currencyDateFormat, "2001-02-28") // a wednesday
+	expected := map[string]string{
+		intervalDaily:   "2001-02-28",
+		intervalWeekly:  "2001-02-26",
+		intervalMonthly: "2001-02-01",
+	}
+
+	for interval, date := range expected {
+		start, err := intervalStart(interval, day)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if start.Format(currencyDateFormat) != date {
+			t.Fatal("Unexpected start:", interval, start)
+		}
+	}
+
+	if _, err := intervalStart("hourly", day); err == nil {
+		t.Fatal("Interval shouldn't be known: hourly")
+	}
+}
+
+func TestSeriesDaily(t *testing.T) {
+	addTestHistory()
+	start, end, _ := parsePeriod("2001-01-31/2001-02-28")
+	res, err := server.createSeries("DKK", "USD", start, end, intervalDaily, []string{"rate", "inverse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Points) != 4 {
+		t.Fatal("Expected four points, got:", len(res.Points))
+	}
+
+	first := res.Points[0]
+	if first["date"] != "2001-01-31" || first["rate"] != 3.75 || first["inverse"] != 1/3.75 {
+		t.Fatal("Unexpected point:", first)
+	}
+
+	// the same cross rate as a conversion on that day
+	rate, _ := crossRate(testHistory[0].rates, "DKK", "USD")
+	if res.Points[3]["rate"] != rate {
+		t.Fatal("Unexpected rate:", res.Points[3])
+	}
+}
+
+func TestSeriesMonthly(t *testing.T) {
+	addTestHistory()
+	var res seriesResponse
+	r := fireReq("/timeseries?from=USD&to=DKK&start=2001-01-01&end=2001-02-28&interval=monthly&fields=open,close,days", http.MethodGet, nil)
+	expect(t, r, http.StatusOK, true, &res)
+	if len(res.Points) != 2 {
+		t.Fatal("Expected two points, got:", len(res.Points))
+	}
+
+	feb := res.Points[1]
+	if feb["date"] != "2001-02-01" || feb["open"] != 7.5 || feb["close"] != 6.0 || feb["days"] != 3.0 {
+		t.Fatal("Unexpected point:", feb)
+	}
+}
+
+func TestSeriesInvalid(t *testing.T) {
+	r := fireReq("/timeseries?from=USD&to=DKK&interval=hourly", http.MethodGet, nil)
+	expect(t, r, http.StatusBadRequest, true, nil)
+
+	r = fireReq("/timeseries?from=USD&to=DKK&fields=rate,median", http.MethodGet, nil)
+	expect(t, r, http.StatusBadRequest, true, nil)
+
+	r = fireReq("/timeseries?from=USD&to=FOO", http.MethodGet, nil)
+	expect(t, r, http.StatusInternalServerError, true, nil)
+}
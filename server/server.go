@@ -3,8 +3,10 @@ package server
 import (
 	"expvar"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"sync"
@@ -15,6 +17,46 @@ const (
 	HostEnvironment = "GFS_CURRENCY_HOST" // hostname environment variable
 	PortEnvironment = "GFS_CURRENCY_PORT" // port environment variable
 
+	ProvidersEnvironment  = "GFS_CURRENCY_PROVIDERS"   // ordered provider list environment variable
+	StaleAfterEnvironment = "GFS_CURRENCY_STALE_AFTER" // max rate age before failover environment variable
+	ToleranceEnvironment  = "GFS_CURRENCY_TOLERANCE"   // cross-check tolerance (percent) environment variable
+	AdminTokenEnvironment = "GFS_CURRENCY_ADMIN_TOKEN" // admin API token environment variable
+	FeesEnvironment       = "GFS_CURRENCY_FEES"        // fee profiles file environment variable
+	HistoryEnvironment    = "GFS_CURRENCY_HISTORY"     // history backfill URL environment variable, "none" disables
+
+	CorsOriginsEnvironment = "GFS_CURRENCY_CORS_ORIGINS" // allowed CORS origins environment variable, empty disables
+	CorsMethodsEnvironment = "GFS_CURRENCY_CORS_METHODS" // allowed CORS methods environment variable
+	CorsHeadersEnvironment = "GFS_CURRENCY_CORS_HEADERS" // allowed CORS request headers environment variable
+	CorsMaxAgeEnvironment  = "GFS_CURRENCY_CORS_MAX_AGE" // CORS preflight max age environment variable
+	JsonpEnvironment       = "GFS_CURRENCY_JSONP"        // "true" enables JSONP callbacks environment variable
+
+	RequireKeysEnvironment = "GFS_CURRENCY_REQUIRE_KEYS" // "true" requires API keys environment variable
+	KeysFileEnvironment    = "GFS_CURRENCY_KEYS_FILE"    // API keys file environment variable, empty keeps them in memory
+	KeyRateEnvironment     = "GFS_CURRENCY_KEY_RATE"     // default requests per minute of a key environment variable
+
+	WebhookSchemesEnvironment = "GFS_CURRENCY_WEBHOOK_SCHEMES" // allowed webhook URL schemes environment variable
+	WebhookAllowEnvironment   = "GFS_CURRENCY_WEBHOOK_ALLOW"   // allowed webhook hosts and ranges environment variable
+	WebhookDenyEnvironment    = "GFS_CURRENCY_WEBHOOK_DENY"    // denied webhook hosts and ranges environment variable
+
+	WebhookMaxFailuresEnvironment = "GFS_CURRENCY_WEBHOOK_MAX_FAILURES" // failures before a webhook is disabled environment variable, 0 never disables
+	NotifyUrlEnvironment          = "GFS_CURRENCY_NOTIFY_URL"           // URL notified about disabled webhooks environment variable
+	CloudEventsEnvironment        = "GFS_CURRENCY_CLOUDEVENTS"          // default CloudEvents mode of the push channels environment variable
+
+	ReadyMaxAgeEnvironment = "GFS_CURRENCY_READY_MAX_AGE" // time rates may be overdue before the server is not ready environment variable
+
+	LogLevelEnvironment  = "GFS_CURRENCY_LOG_LEVEL"  // log level (debug, info, warn or error) environment variable
+	LogFormatEnvironment = "GFS_CURRENCY_LOG_FORMAT" // log format (json or text) environment variable
+
+	TraceExporterEnvironment = "GFS_CURRENCY_TRACE_EXPORTER" // trace span exporter ("stdout") environment variable, empty disables
+
+	ConfigEnvironment         = "GFS_CURRENCY_CONFIG"          // configuration file environment variable, read by the command
+	EcbUrlEnvironment         = "GFS_CURRENCY_ECB_URL"         // URL of the ECB reference rates environment variable
+	UpdateIntervalEnvironment = "GFS_CURRENCY_UPDATE_INTERVAL" // time between rate updates environment variable
+	RetryIntervalEnvironment  = "GFS_CURRENCY_RETRY_INTERVAL"  // time before retrying a failed update environment variable
+	FetchTimeoutEnvironment   = "GFS_CURRENCY_FETCH_TIMEOUT"   // timeout of a provider fetch environment variable
+	WebhookTimeoutEnvironment = "GFS_CURRENCY_WEBHOOK_TIMEOUT" // timeout of a webhook call environment variable
+	DefaultBaseEnvironment    = "GFS_CURRENCY_DEFAULT_BASE"    // base currency of /currencies without a base environment variable
+
 	defaultHost = "127.0.0.1" // default hostname
 	defaultPort = "4000"      // default port number
 )
@@ -23,71 +65,320 @@ type Server struct {
 	host string
 	port int
 
+	rateMutex      *sync.RWMutex      // used for locking the rates, the overrides and the fetch status
 	hasCurrencies  bool               // true if currencies have been properly fetched+parsed
 	lastUpdateTime time.Time          // time parsed from timestamp in ECB data
-	currencies     map[string]float64 // currency data
+	currencies     map[string]float64 // currency data, fetched rates merged with the overrides
+	feed           map[string]float64 // currency data as fetched from the provider
+	version        string             // version of the currency data
+	ratesChanged   time.Time          // time the currency data last changed, by a fetch or an override
+	lastFetch      time.Time          // time of the last successful fetch
+	lastFetchError string             // error of the last failed fetch
+	lastErrorTime  time.Time          // time of the last failed fetch
+	readyMaxAge    time.Duration      // time the next rates may be overdue while ready
+	defaultBase    string             // base currency of /currencies without a base
+
+	history    *rateHistory // the daily rates
+	historyUrl string       // URL to backfill the history from, empty disables
+
+	adminToken string                  // token for the admin API, empty disables it
+	overrides  map[string]rateOverride // manually set rates
+
+	feeProfiles map[string]feeProfile // spread and fee profiles for conversions
+
+	cors  corsConfig // cross origin settings
+	jsonp bool       // true if JSON responses may be wrapped in a callback
+
+	apiKeys     map[string]*apiKey // API keys by the hash of the key
+	keysFile    string             // file the API keys are saved to, empty disables
+	keyMutex    *sync.Mutex        // used for locking the API keys and their buckets
+	requireKeys bool               // true if requests must carry an API key
+	keyRate     float64            // default requests per minute of a key
+
+	providers  []provider                // ordered list of providers to fetch from
+	staleAfter time.Duration             // rates older than this cause a failover
+	tolerance  float64                   // max deviation in percent when cross-checking, 0 disables
+	provider   string                    // name of the provider of the current rates
+	mismatches map[string][]rateMismatch // currencies where the providers disagree
+
+	updateInterval time.Duration // time between rate updates
+	retryInterval  time.Duration // time before retrying a failed update
+	fetchTimeout   time.Duration // timeout of a single provider fetch
 
 	mutex    *sync.Mutex        // used for locking when handling webhooks
 	webhooks map[string]webhook // holds webhooks
+	pending  map[string]webhook // re-registrations of webhooks, kept apart until they pass the challenge
+
+	webhookPolicy *urlPolicy   // the URLs webhooks may call
+	webhookClient *http.Client // the client calling webhooks, enforces the policy
+
+	attempts           map[registrationKey][]deliveryAttempt // the last calls of every webhook registration
+	attemptMutex       *sync.Mutex                           // used for locking the attempts
+	webhookMaxFailures int                                   // consecutive failures before a webhook is disabled, 0 never disables
+	notifyUrl          string                                // URL notified about disabled webhooks, empty disables
+	cloudEvents        string                                // default CloudEvents mode of the push channels, empty disables
 
 	currencyHits    *expvar.Int
 	convertHits     *expvar.Int
 	webhookHits     *expvar.Int
 	webhookTriggers *expvar.Int
+	webhookDisables *expvar.Int
+
+	providerName      *expvar.String
+	providerFailovers *expvar.Int
+	rateMismatches    *expvar.Int
+
+	logger  *slog.Logger // the logger of the server, the request loggers derive from it
+	metrics *metrics     // the Prometheus metrics
+	tracer  *tracer      // the tracer exporting the spans
 }
 
 // representation of a webhook
 type webhook struct {
 	BaseCurrency string `json:"base_currency"`
 	Url          string `json:"url"`
-	Secret       string `json:"secret"`
+	Secret       string `json:"secret,omitempty"`
+	Status       string `json:"status"`
+	Failures     int    `json:"failures,omitempty"` // consecutive failed deliveries
+
+	Format      string   `json:"format,omitempty"`       // payload format, JSON by default
+	Template    string   `json:"template,omitempty"`     // Go template of the payload
+	ContentType string   `json:"content_type,omitempty"` // content type of the template payload
+	Symbols     []string `json:"symbols,omitempty"`      // currencies to send, all if empty
+	CloudEvents string   `json:"cloudevents,omitempty"`  // CloudEvents mode, the server default if empty
+
+	registered time.Time // time of the registration, pending hooks expire
 }
 
-// Creates a new server. Creation reads environment variables to configure
-// hostname and port.
+// Creates a new server configured by the defaults and the environment,
+// logging to stderr.
 func New() (s *Server, err error) {
-	// get config from environment
-	host := getEnv(HostEnvironment, defaultHost)
-	portStr := getEnv(PortEnvironment, defaultPort)
+	c := DefaultConfig()
+	c.LoadEnv()
+
+	logger, err := c.Logger(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWithConfig(c, logger)
+}
+
+// Creates a new server from the configuration, logging to the logger. Every
+// value is validated, the first invalid one is returned as the error.
+func NewWithConfig(c *Config, logger *slog.Logger) (s *Server, err error) {
+	// the log settings are validated even if the logger is made elsewhere
+	if _, err = c.Logger(io.Discard); err != nil {
+		return nil, err
+	}
+
+	host := c.get("host")
+	portStr := c.get("port")
 	port, err := strconv.Atoi(portStr)
 	if err != nil {
 		return nil, fmt.Errorf("Error parsing port number: %s", portStr)
 	}
 
+	providers, err := parseProviders(c.get("providers"))
+	if err != nil {
+		return nil, err
+	}
+
+	// the ECB without its own URL fetches from the configured one
+	ecbUrl, err := url.Parse(c.get("ecb_url"))
+	if err != nil || (ecbUrl.Scheme != "http" && ecbUrl.Scheme != "https") || ecbUrl.Host == "" {
+		return nil, fmt.Errorf("Error parsing ECB URL: %s", c.get("ecb_url"))
+	}
+	for i, p := range providers {
+		if p.name == "ecb" && p.url == ecbCurrencyUrl {
+			providers[i].url = ecbUrl.String()
+		}
+	}
+
+	staleStr := c.get("stale_after")
+	staleAfter, err := time.ParseDuration(staleStr)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing stale duration: %s", staleStr)
+	}
+
+	toleranceStr := c.get("tolerance")
+	tolerance, err := strconv.ParseFloat(toleranceStr, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing tolerance: %s", toleranceStr)
+	}
+
+	updateInterval, err := c.duration("update_interval")
+	if err != nil {
+		return nil, err
+	}
+
+	retryInterval, err := c.duration("retry_interval")
+	if err != nil {
+		return nil, err
+	}
+
+	fetchTimeout, err := c.duration("fetch_timeout")
+	if err != nil {
+		return nil, err
+	}
+
+	defaultBase := c.get("default_base")
+	if !validCurrencyCode(defaultBase) {
+		return nil, fmt.Errorf("Error parsing default base: %s", defaultBase)
+	}
+
+	feeProfiles, err := loadFeeProfiles(c.get("fees"))
+	if err != nil {
+		return nil, err
+	}
+
+	historyUrl := c.get("history")
+	if historyUrl == "none" {
+		historyUrl = ""
+	}
+
+	cors, err := parseCors(c.get("cors_origins"), c.get("cors_methods"), c.get("cors_headers"), c.get("cors_max_age"))
+	if err != nil {
+		return nil, err
+	}
+
+	jsonp, err := c.boolean("jsonp")
+	if err != nil {
+		return nil, err
+	}
+
+	requireKeys, err := c.boolean("require_keys")
+	if err != nil {
+		return nil, err
+	}
+
+	keysFile := c.get("keys_file")
+	apiKeys, err := loadApiKeys(keysFile)
+	if err != nil {
+		return nil, err
+	}
+
+	keyRateStr := c.get("key_rate")
+	keyRate, err := strconv.ParseFloat(keyRateStr, 64)
+	if err != nil || keyRate <= 0 {
+		return nil, fmt.Errorf("Error parsing key rate: %s", keyRateStr)
+	}
+
+	webhookPolicy, err := newUrlPolicy(c.get("webhook_schemes"), c.get("webhook_allow"), c.get("webhook_deny"))
+	if err != nil {
+		return nil, err
+	}
+
+	webhookPolicy.timeout, err = c.duration("webhook_timeout")
+	if err != nil {
+		return nil, err
+	}
+
+	maxFailuresStr := c.get("webhook_max_failures")
+	maxFailures, err := strconv.Atoi(maxFailuresStr)
+	if err != nil || maxFailures < 0 {
+		return nil, fmt.Errorf("Error parsing webhook max failures: %s", maxFailuresStr)
+	}
+
+	cloudEvents := c.get("cloudevents")
+	if err = checkCloudEventsMode(cloudEvents); err != nil {
+		return nil, err
+	}
+
+	readyMaxAgeStr := c.get("ready_max_age")
+	readyMaxAge, err := time.ParseDuration(readyMaxAgeStr)
+	if err != nil || readyMaxAge < 0 {
+		return nil, fmt.Errorf("Error parsing ready max age: %s", readyMaxAgeStr)
+	}
+
+	exporter, err := newExporter(c.get("trace_exporter"))
+	if err != nil {
+		return nil, err
+	}
+
 	// initialize internal variables
 	return &Server{
 		host: host,
 		port: port,
 
+		rateMutex:     &sync.RWMutex{},
 		hasCurrencies: false,
+		readyMaxAge:   readyMaxAge,
+		defaultBase:   defaultBase,
+
+		history:    newRateHistory(),
+		historyUrl: historyUrl,
+
+		adminToken: c.get("admin_token"),
+		overrides:  make(map[string]rateOverride),
+
+		feeProfiles: feeProfiles,
+
+		cors:  cors,
+		jsonp: jsonp,
+
+		apiKeys:     apiKeys,
+		keysFile:    keysFile,
+		keyMutex:    &sync.Mutex{},
+		requireKeys: requireKeys,
+		keyRate:     keyRate,
+
+		providers:      providers,
+		staleAfter:     staleAfter,
+		tolerance:      tolerance,
+		updateInterval: updateInterval,
+		retryInterval:  retryInterval,
+		fetchTimeout:   fetchTimeout,
 
 		mutex:    &sync.Mutex{},
 		webhooks: make(map[string]webhook),
+		pending:  make(map[string]webhook),
+
+		webhookPolicy: webhookPolicy,
+		webhookClient: webhookPolicy.client(),
+
+		attempts:           make(map[registrationKey][]deliveryAttempt),
+		attemptMutex:       &sync.Mutex{},
+		webhookMaxFailures: maxFailures,
+		notifyUrl:          c.get("notify_url"),
+		cloudEvents:        cloudEvents,
 
-		currencyHits:    expvar.NewInt("currency_hits"),
-		convertHits:     expvar.NewInt("convert_hits"),
-		webhookHits:     expvar.NewInt("webhook_hits"),
-		webhookTriggers: expvar.NewInt("webhook_triggers"),
+		currencyHits:    new(expvar.Int),
+		convertHits:     new(expvar.Int),
+		webhookHits:     new(expvar.Int),
+		webhookTriggers: new(expvar.Int),
+		webhookDisables: new(expvar.Int),
+
+		providerName:      new(expvar.String),
+		providerFailovers: new(expvar.Int),
+		rateMismatches:    new(expvar.Int),
+
+		logger:  logger,
+		metrics: newMetrics(),
+		tracer:  newTracer(exporter),
 	}, nil
 }
 
 // Runs the server and returns error from http.ListenAndServe
 func (s *Server) Run() (err error) {
-	log.Printf("Starting server on %s:%d\n", s.host, s.port)
+	s.logger.Info("Starting server", "host", s.host, "port", s.port)
 
 	// starts the currency updating goroutine
 	s.startCurrencyUpdating()
-	http.Handle("/", s)
-	return http.ListenAndServe(fmt.Sprintf("%s:%d", s.host, s.port), nil)
+	return http.ListenAndServe(fmt.Sprintf("%s:%d", s.host, s.port), s)
 }
 
-// gets the value of the given environment variable, if no value is set
-// the given default is returned
-func getEnv(env, def string) (val string) {
-	val = os.Getenv(env)
-	if val == "" {
-		val = def
-	}
+// Publishes the counters of the server as expvars, served at /debug/vars.
+// The expvars are global, like expvar.Publish this panics if a server was
+// published before.
+func (s *Server) PublishVars() {
+	expvar.Publish("currency_hits", s.currencyHits)
+	expvar.Publish("convert_hits", s.convertHits)
+	expvar.Publish("webhook_hits", s.webhookHits)
+	expvar.Publish("webhook_triggers", s.webhookTriggers)
+	expvar.Publish("webhook_disables", s.webhookDisables)
 
-	return val
+	expvar.Publish("rate_provider", s.providerName)
+	expvar.Publish("provider_failovers", s.providerFailovers)
+	expvar.Publish("rate_mismatches", s.rateMismatches)
 }
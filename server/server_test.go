@@ -1,16 +1,24 @@
 package server
 
+import (
+	"os"
+)
+
 var (
 	server   *Server
 	runError error
 )
 
 func init() {
+	os.Setenv(AdminTokenEnvironment, testAdminToken)
+	os.Setenv(WebhookAllowEnvironment, "127.0.0.1")
+
 	var err error
 	server, err = New()
 	if err != nil {
 		panic(err)
 	}
+	server.PublishVars()
 
 	go func() {
 		runError = server.Run()
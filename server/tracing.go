@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// the span kinds, as in OpenTelemetry
+const (
+	spanServer   = "server"   // handles an incoming request
+	spanClient   = "client"   // an outgoing request
+	spanInternal = "internal" // work within the server
+
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+// a W3C traceparent header: version, trace ID, parent span ID and flags
+var traceparent = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})(-.*)?$`)
+
+// A finished span. The IDs are hex encoded like in the W3C trace context and
+// OpenTelemetry, so spans can be correlated with the spans of the callers.
+type Span struct {
+	TraceID      string                 `json:"trace_id"`
+	SpanID       string                 `json:"span_id"`
+	ParentSpanID string                 `json:"parent_span_id,omitempty"`
+	Name         string                 `json:"name"`
+	Kind         string                 `json:"kind"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Status       string                 `json:"status"` // ok or error
+	Error        string                 `json:"error,omitempty"`
+}
+
+// Receives the finished spans. Exporters are called concurrently.
+type SpanExporter interface {
+	ExportSpan(span Span)
+}
+
+// An exporter writing every span as a line of JSON.
+type writerExporter struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+// Returns an exporter writing the spans as JSON lines to w.
+func NewWriterExporter(w io.Writer) SpanExporter {
+	return &writerExporter{encoder: json.NewEncoder(w)}
+}
+
+func (e *writerExporter) ExportSpan(span Span) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.encoder.Encode(span)
+}
+
+// An exporter keeping the spans in memory, eg. for tests.
+type MemoryExporter struct {
+	mutex sync.Mutex
+	spans []Span
+}
+
+func (e *MemoryExporter) ExportSpan(span Span) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.spans = append(e.spans, span)
+}
+
+// Returns the exported spans, oldest first.
+func (e *MemoryExporter) Spans() []Span {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return append([]Span{}, e.spans...)
+}
+
+// Returns the exporter of the name: "stdout" or empty for none.
+func newExporter(name string) (SpanExporter, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "stdout":
+		return NewWriterExporter(os.Stdout), nil
+	}
+
+	return nil, fmt.Errorf("Unknown trace exporter: %s", name)
+}
+
+// The tracer of a server, spans are dropped without an exporter.
+type tracer struct {
+	mutex    *sync.Mutex
+	exporter SpanExporter
+}
+
+func newTracer(exporter SpanExporter) *tracer {
+	return &tracer{mutex: &sync.Mutex{}, exporter: exporter}
+}
+
+// exports the span if an exporter is set
+func (t *tracer) export(span Span) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	exporter := t.exporter
+	t.mutex.Unlock()
+
+	if exporter != nil {
+		exporter.ExportSpan(span)
+	}
+}
+
+// Sets the exporter receiving the finished spans, nil drops them.
+func (s *Server) SetSpanExporter(exporter SpanExporter) {
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+
+	s.tracer.exporter = exporter
+}
+
+// The identity of a span, propagated in the context and the traceparent
+// header. Without a trace ID a new trace is started.
+type spanContext struct {
+	traceId string
+	spanId  string
+	state   string // the tracestate of the caller, passed on as is
+	sampled bool
+	tracer  *tracer
+}
+
+// the key of the span context in the context
+type spanContextKey struct{}
+
+// Returns the context carrying the tracer and, if the header has a valid
+// traceparent, the span of the caller as the parent.
+func (t *tracer) context(ctx context.Context, header http.Header) context.Context {
+	sc := spanContext{sampled: true, tracer: t}
+
+	m := traceparent.FindStringSubmatch(header.Get(traceparentHeader))
+	if m != nil && m[1] != "ff" && (m[1] != "00" || m[5] == "") &&
+		m[2] != "00000000000000000000000000000000" && m[3] != "0000000000000000" {
+		flags, _ := hex.DecodeString(m[4])
+		sc.traceId, sc.spanId, sc.sampled = m[2], m[3], flags[0]&1 == 1
+		sc.state = header.Get(tracestateHeader)
+	}
+
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// A span in progress, ended exactly once.
+type activeSpan struct {
+	span    Span
+	sampled bool
+	tracer  *tracer
+}
+
+// Starts a span as a child of the span in the context, or as the root of a
+// new trace. Returns the context carrying the new span.
+func startSpan(ctx context.Context, name, kind string) (context.Context, *activeSpan) {
+	parent, _ := ctx.Value(spanContextKey{}).(spanContext)
+
+	sc := parent
+	if sc.traceId == "" {
+		sc.traceId, _ = randomHex(16)
+		sc.sampled = true
+	}
+	sc.spanId, _ = randomHex(8)
+
+	a := &activeSpan{
+		span: Span{
+			TraceID:      sc.traceId,
+			SpanID:       sc.spanId,
+			ParentSpanID: parent.spanId,
+			Name:         name,
+			Kind:         kind,
+			Start:        time.Now().UTC(),
+			Attributes:   make(map[string]interface{}),
+		},
+		sampled: sc.sampled,
+		tracer:  sc.tracer,
+	}
+
+	return context.WithValue(ctx, spanContextKey{}, sc), a
+}
+
+// sets an attribute of the span
+func (a *activeSpan) set(key string, value interface{}) {
+	a.span.Attributes[key] = value
+}
+
+// ends the span with the error, exports it if sampled
+func (a *activeSpan) end(err error) {
+	a.span.End = time.Now().UTC()
+	a.span.Status = "ok"
+	if err != nil {
+		a.span.Status, a.span.Error = "error", err.Error()
+	}
+
+	if a.sampled {
+		a.tracer.export(a.span)
+	}
+}
+
+// Sets the traceparent (and tracestate) header of the span in the context,
+// so the callee can continue the trace.
+func injectTrace(ctx context.Context, header http.Header) {
+	sc, ok := ctx.Value(spanContextKey{}).(spanContext)
+	if !ok || sc.traceId == "" {
+		return
+	}
+
+	flags := "00"
+	if sc.sampled {
+		flags = "01"
+	}
+
+	header.Set(traceparentHeader, fmt.Sprintf("00-%s-%s-%s", sc.traceId, sc.spanId, flags))
+	if sc.state != "" {
+		header.Set(tracestateHeader, sc.state)
+	}
+}
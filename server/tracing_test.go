@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	testTraceId  = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testParentId = "00f067aa0ba902b7"
+)
+
+// returns the header with the traceparent and tracestate
+func traceHeader(traceparent, tracestate string) http.Header {
+	header := http.Header{}
+	header.Set(traceparentHeader, traceparent)
+	if tracestate != "" {
+		header.Set(tracestateHeader, tracestate)
+	}
+
+	return header
+}
+
+// returns the spans with the name
+func spansNamed(spans []Span, name string) (named []Span) {
+	for _, span := range spans {
+		if span.Name == name {
+			named = append(named, span)
+		}
+	}
+
+	return named
+}
+
+func TestTraceparent(t *testing.T) {
+	exporter := &MemoryExporter{}
+	tr := newTracer(exporter)
+
+	for header, continued := range map[string]bool{
+		"00-" + testTraceId + "-" + testParentId + "-01":                  true,
+		"01-" + testTraceId + "-" + testParentId + "-01-future":           true,
+		"00-" + testTraceId + "-" + testParentId + "-01-extra":            false,
+		"ff-" + testTraceId + "-" + testParentId + "-01":                  false,
+		"00-" + strings.ToUpper(testTraceId) + "-" + testParentId + "-01": false,
+		"00-00000000000000000000000000000000-" + testParentId + "-01":     false,
+		"00-" + testTraceId + "-0000000000000000-01":                      false,
+		"": false,
+	} {
+		ctx := tr.context(context.Background(), traceHeader(header, ""))
+		_, span := startSpan(ctx, "test", spanServer)
+		if (span.span.TraceID == testTraceId && span.span.ParentSpanID == testParentId) != continued {
+			t.Fatal("Unexpected span for the header:", header, span.span)
+		}
+
+		if len(span.span.TraceID) != 32 || len(span.span.SpanID) != 16 {
+			t.Fatal("Unexpected IDs:", span.span)
+		}
+	}
+
+	// unsampled traces are propagated but not exported
+	ctx := tr.context(context.Background(), traceHeader("00-"+testTraceId+"-"+testParentId+"-00", ""))
+	ctx, span := startSpan(ctx, "unsampled", spanServer)
+	span.end(nil)
+
+	header := http.Header{}
+	injectTrace(ctx, header)
+	if len(exporter.Spans()) != 0 || header.Get(traceparentHeader) != "00-"+testTraceId+"-"+span.span.SpanID+"-00" {
+		t.Fatal("Unexpected unsampled trace:", exporter.Spans(), header)
+	}
+}
+
+func TestTraceRequest(t *testing.T) {
+	exporter := &MemoryExporter{}
+	server.SetSpanExporter(exporter)
+	defer server.SetSpanExporter(nil)
+
+	r := fireReqHeaders("/currencies", http.MethodGet, nil, map[string]string{
+		traceparentHeader: "00-" + testTraceId + "-" + testParentId + "-01",
+		tracestateHeader:  "vendor=value",
+	})
+	expect(t, r, http.StatusOK, true, nil)
+
+	spans := spansNamed(exporter.Spans(), "GET /currencies")
+	if len(spans) != 1 {
+		t.Fatal("Expected a span for the request:", exporter.Spans())
+	}
+
+	span := spans[0]
+	if span.TraceID != testTraceId || span.ParentSpanID != testParentId || span.Kind != spanServer ||
+		span.Status != "ok" || span.Attributes["http.status_code"] != http.StatusOK {
+		t.Fatal("Unexpected span:", span)
+	}
+}
+
+func TestTraceWebhook(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header
+	}))
+	defer ts.Close()
+
+	exporter := &MemoryExporter{}
+	server.SetSpanExporter(exporter)
+	defer server.SetSpanExporter(nil)
+
+	ctx := server.tracer.context(context.Background(), traceHeader("00-"+testTraceId+"-"+testParentId+"-01", "vendor=value"))
+	if err := server.callSingleWebhook(ctx, webhook{BaseCurrency: eur, Url: ts.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	header := <-headers
+	spans := spansNamed(exporter.Spans(), "webhook.deliver")
+	if len(spans) != 1 || spans[0].TraceID != testTraceId || spans[0].ParentSpanID != testParentId {
+		t.Fatal("Expected a span for the delivery:", exporter.Spans())
+	}
+
+	if header.Get(traceparentHeader) != "00-"+testTraceId+"-"+spans[0].SpanID+"-01" || header.Get(tracestateHeader) != "vendor=value" {
+		t.Fatal("Expected the trace to be propagated:", header)
+	}
+}
+
+func TestTraceFetch(t *testing.T) {
+	ts := frankfurterServer("2026-10-16", 7.45)
+	defer ts.Close()
+
+	exporter := &MemoryExporter{}
+	s := providerServer(0, ts.URL)
+	s.tracer = newTracer(exporter)
+
+	ctx, span := startSpan(s.tracer.context(context.Background(), nil), "rates.update", spanInternal)
+	if _, err := s.fetchRates(ctx); err != nil {
+		t.Fatal(err)
+	}
+	span.end(nil)
+
+	spans := exporter.Spans()
+	for i, name := range []string{"rates.fetch", "rates.parse", "provider.fetch", "rates.update"} {
+		if i >= len(spans) || spans[i].Name != name || spans[i].TraceID != span.span.TraceID {
+			t.Fatal("Unexpected spans:", spans)
+		}
+	}
+
+	if spans[0].Attributes["http.status_code"] != http.StatusOK || spans[1].Attributes["rates.count"] != 3 ||
+		spans[2].ParentSpanID != span.span.SpanID || spans[0].ParentSpanID != spans[2].SpanID {
+		t.Fatal("Unexpected spans:", spans)
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"text/template"
+)
+
+// Handles the TypeScript declaration requests (/script.d.ts). The
+// declarations match the script for the same base and variant, every known
+// currency code is part of the CurrencyCode union.
+func (s *Server) typescriptHandler(w http.ResponseWriter, r *http.Request) {
+	base := r.URL.Query().Get("base")
+	res, err := s.createResponse(base)
+	if err != nil {
+		http.Error(w, "Error creating response", http.StatusInternalServerError)
+		return
+	}
+
+	variant := "global"
+	if r.URL.Query().Get("module") == "true" {
+		variant = "module"
+	}
+
+	if s.cacheResponse(w, r, base+"-"+variant+".d.ts") {
+		return
+	}
+
+	// render before writing so errors can still be reported
+	b := &bytes.Buffer{}
+	err = typescriptTemplate.ExecuteTemplate(b, variant, createScriptData(res))
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/typescript; charset=utf-8")
+	w.Write(b.Bytes())
+}
+
+// quotes a string as a JSON (and TypeScript) string literal
+func quote(s string) (string, error) {
+	data, err := json.Marshal(s)
+	return string(data), err
+}
+
+// the TypeScript declaration template, parsed once
+var typescriptTemplate = template.Must(template.New("typescript").Funcs(template.FuncMap{"quote": quote}).Parse(typescriptSource))
+
+var typescriptSource = `
+{{- define "types" -}}
+// Rates of {{ .Date }} relative to {{ .Base }} (version {{ .Version }})
+
+/** The known currency codes. */
+export type CurrencyCode ={{ range $i, $c := .Currencies }}{{ if $i }} |{{ end }} {{ quote $c }}{{ end }};
+
+export interface Currency {
+	/** The date of the rates (YYYY-MM-DD). */
+	readonly date: string;
+	/** The currency the rates are relative to. */
+	readonly base: {{ quote .Base }};
+	/** The version of the rates. */
+	readonly version: string;
+	readonly currencies: readonly CurrencyCode[];
+	readonly rates: Readonly<Record<CurrencyCode, number>>;
+	readonly minorUnits: Readonly<Record<CurrencyCode, number>>;
+
+	/** Rounds half away from zero. */
+	round(amount: number, decimals: number): number;
+
+	/** Converts the amount from the base, rounded to the minor units of the target. */
+	convert(amount: number, to: CurrencyCode): number;
+
+	/** Converts the amount, rounded to the minor units of the target unless decimals is given (null disables rounding). */
+	convert(amount: number, from: CurrencyCode, to: CurrencyCode, decimals?: number | null): number;
+}
+{{- end -}}
+
+{{- define "global" -}}
+{{ template "types" . }}
+
+declare global {
+	interface Window {
+		currency: Currency;
+	}
+}
+{{ end -}}
+
+{{- define "module" -}}
+{{ template "types" . }}
+
+export declare const date: string;
+export declare const base: {{ quote .Base }};
+export declare const currencies: readonly CurrencyCode[];
+export declare const rates: Readonly<Record<CurrencyCode, number>>;
+export declare function convert(amount: number, to: CurrencyCode): number;
+export declare function convert(amount: number, from: CurrencyCode, to: CurrencyCode, decimals?: number | null): number;
+
+declare const currency: Currency;
+export default currency;
+{{ end -}}
+`
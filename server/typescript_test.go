@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTypescriptGlobal(t *testing.T) {
+	r := fireReq("/script.d.ts?base=DKK", http.MethodGet, nil)
+	expect(t, r, http.StatusOK, true, nil)
+
+	body := r.Body.String()
+	res, _ := server.createResponse("DKK")
+	data := createScriptData(res)
+	union := `export type CurrencyCode = "` + strings.Join(data.Currencies, `" | "`) + `";`
+	if !strings.Contains(body, union) {
+		t.Fatal("Expected the currency code union:", body)
+	}
+
+	if !strings.Contains(body, "interface Window") || strings.Contains(body, "export default") {
+		t.Fatal("Expected the global declarations:", body)
+	}
+
+	if !strings.Contains(body, `readonly base: "DKK";`) {
+		t.Fatal("Expected the base:", body)
+	}
+}
+
+func TestTypescriptModule(t *testing.T) {
+	r := fireReq("/script.d.ts?base=DKK&module=true", http.MethodGet, nil)
+	expect(t, r, http.StatusOK, true, nil)
+
+	body := r.Body.String()
+	if !strings.Contains(body, "export default currency;") || strings.Contains(body, "interface Window") {
+		t.Fatal("Expected the module declarations:", body)
+	}
+}
+
+func TestTypescriptUnknownBase(t *testing.T) {
+	r := fireReq("/script.d.ts?base=FOO", http.MethodGet, nil)
+	expect(t, r, http.StatusInternalServerError, true, nil)
+}
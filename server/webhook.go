@@ -2,83 +2,370 @@ package server
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
-// verifies a single webhook. Looks up the base currency, parses the
-// URL and attempts to call the webhook
-func (s *Server) verifyWebhook(hook webhook) bool {
-	if !s.hasCurrencies {
-		return false
+// the states of a webhook
+const (
+	webhookPending  = "pending"  // registered, the challenge is not echoed yet
+	webhookActive   = "active"   // verified, called on every update
+	webhookDisabled = "disabled" // failed too often, not called anymore
+
+	pendingWebhookTTL = 24 * time.Hour // time a webhook may stay pending
+	maxChallengeSize  = 1024           // max size of a challenge answer
+)
+
+// verifies a single webhook. Looks up the base currency, validates the
+// payload settings and checks the URL against the webhook policy
+func (s *Server) verifyWebhook(hook *webhook) error {
+	s.rateMutex.RLock()
+	hasCurrencies := s.hasCurrencies
+	_, hasBase := s.currencies[hook.BaseCurrency]
+	s.rateMutex.RUnlock()
+
+	if !hasCurrencies {
+		return fmt.Errorf("No currencies")
 	}
 
-	if _, hasBase := s.currencies[hook.BaseCurrency]; !hasBase {
-		return false
+	if !hasBase {
+		return fmt.Errorf("Unknown currency: %s", hook.BaseCurrency)
 	}
 
-	if _, err := url.Parse(hook.Url); err != nil {
-		return false
+	if err := s.checkPayload(hook); err != nil {
+		return err
 	}
 
-	err := s.callSingleWebhook(hook)
+	return s.webhookPolicy.checkUrl(hook.Url)
+}
+
+// Registers the webhook as pending and challenges it. A hook that echoes
+// the challenge becomes active and is called with the current rates right
+// away. A pending hook never replaces a verified hook of the URL, it is
+// kept apart until it passes the challenge. Returns the registered hook.
+func (s *Server) registerWebhook(ctx context.Context, hook webhook) webhook {
+	hook.Status = webhookPending
+	hook.registered = time.Now()
+
+	err := s.challengeWebhook(ctx, hook)
 	if err != nil {
+		s.webhookLogger(hook).Info("Webhook pending", "error", err)
+	} else {
+		hook.Status = webhookActive
+	}
+
+	s.mutex.Lock()
+	s.storeWebhook(hook)
+	s.webhookHits.Add(1)
+	s.mutex.Unlock()
+
+	if hook.Status == webhookActive {
+		err = s.callSingleWebhook(ctx, hook)
+		hook = s.updateWebhook(hook, err)
+	}
+
+	return hook
+}
+
+// Calls all active webhooks. Pending webhooks are challenged again and
+// called once they are verified, those pending too long are dropped.
+// Disabled webhooks are skipped. The calls are made on a copy of the
+// webhooks, the lock is not held while waiting for slow receivers.
+func (s *Server) callWebhooks(ctx context.Context) {
+	// the re-registrations go first, a verified one replaces its webhook
+	s.mutex.Lock()
+	hooks := make([]webhook, 0, len(s.pending)+len(s.webhooks))
+	for _, hook := range s.pending {
+		hooks = append(hooks, hook)
+	}
+	for _, hook := range s.webhooks {
+		hooks = append(hooks, hook)
+	}
+	s.mutex.Unlock()
+
+	ctx, span := startSpan(ctx, "webhooks.fanout", spanInternal)
+	span.set("webhooks.count", len(hooks))
+	defer span.end(nil)
+
+	// the webhooks left to call, reported as the queue depth
+	depth := len(hooks)
+	defer s.metrics.setQueueDepth(0)
+
+	for _, hook := range hooks {
+		s.metrics.setQueueDepth(depth)
+		depth--
+		if hook.Status == webhookDisabled {
+			continue
+		}
+
+		if hook.Status == webhookPending {
+			if time.Since(hook.registered) > pendingWebhookTTL {
+				s.webhookLogger(hook).Warn("Webhook never verified, dropped")
+				s.dropWebhook(hook)
+				continue
+			}
+
+			if err := s.challengeWebhook(ctx, hook); err != nil {
+				s.webhookLogger(hook).Info("Webhook still pending", "error", err)
+				continue
+			}
+
+			hook.Status = webhookActive
+			if !s.activateWebhook(hook) {
+				continue
+			}
+		} else if !s.stillRegistered(hook) {
+			// removed or replaced since the copy was made
+			continue
+		}
+
+		err := s.callSingleWebhook(ctx, hook)
+		s.updateWebhook(hook, err)
+	}
+}
+
+// Stores the registered webhook. A verified webhook replaces every
+// registration of its URL, a pending one is kept apart from a verified
+// webhook so a subscription can't be taken over without passing the
+// challenge. Must be called while holding the lock.
+func (s *Server) storeWebhook(hook webhook) {
+	current, found := s.webhooks[hook.Url]
+	if found && hook.Status == webhookPending && current.Status != webhookPending {
+		s.replaceRegistration(s.pending, hook)
+		return
+	}
+
+	if pending, found := s.pending[hook.Url]; found && !pending.registered.Equal(hook.registered) {
+		s.clearAttempts(pending)
+	}
+	delete(s.pending, hook.Url)
+	s.replaceRegistration(s.webhooks, hook)
+}
+
+// stores the webhook in the registrations, the log of the registration it
+// replaces is cleared. Must be called while holding the lock.
+func (s *Server) replaceRegistration(registrations map[string]webhook, hook webhook) {
+	if current, found := registrations[hook.Url]; found && !current.registered.Equal(hook.registered) {
+		s.clearAttempts(current)
+	}
+
+	registrations[hook.Url] = hook
+}
+
+// returns true if the stored webhook is the same registration as the hook,
+// must be called while holding the lock
+func (s *Server) isRegistered(hook webhook) bool {
+	current, found := s.webhooks[hook.Url]
+	return found && current.registered.Equal(hook.registered)
+}
+
+// returns true if the pending re-registration is the same registration as
+// the hook, must be called while holding the lock
+func (s *Server) isPending(hook webhook) bool {
+	current, found := s.pending[hook.Url]
+	return found && current.registered.Equal(hook.registered)
+}
+
+// returns true if the webhook is still registered, locks while checking
+func (s *Server) stillRegistered(hook webhook) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.isRegistered(hook)
+}
+
+// Stores the webhook after it passed the challenge. Returns false if it was
+// removed or registered again in the meantime.
+func (s *Server) activateWebhook(hook webhook) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.isRegistered(hook) && !s.isPending(hook) {
 		return false
 	}
 
+	s.storeWebhook(hook)
 	return true
 }
 
-// calls all webhooks
-func (s *Server) callWebhooks() {
+// Stores the webhook with its health after a call. Webhooks removed or
+// registered again during the call are left alone. Returns the stored hook.
+func (s *Server) updateWebhook(hook webhook, err error) webhook {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	for _, hook := range s.webhooks {
-		s.callSingleWebhook(hook)
+	if !s.isRegistered(hook) {
+		// the log of a replaced registration isn't listed anymore
+		if !s.isPending(hook) {
+			s.clearAttempts(hook)
+		}
+		return hook
+	}
+
+	hook = s.updateHealth(hook, err)
+	s.webhooks[hook.Url] = hook
+	return hook
+}
+
+// Removes the pending webhook with its log. A re-registration is dropped on
+// its own, the verified webhook of the URL keeps its log. Webhooks
+// registered again in the meantime are left alone.
+func (s *Server) dropWebhook(hook webhook) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.isPending(hook) {
+		delete(s.pending, hook.Url)
+	} else if s.isRegistered(hook) {
+		delete(s.webhooks, hook.Url)
+	} else {
+		return
+	}
+
+	s.clearAttempts(hook)
+}
+
+// Sends a random challenge to the webhook, similar to WebSub intent
+// verification. The webhook must answer with a 2xx status and the challenge
+// as the body.
+func (s *Server) challengeWebhook(ctx context.Context, hook webhook) (err error) {
+	ctx, span := startSpan(ctx, "webhook.challenge", spanClient)
+	span.set("webhook.url", hook.Url)
+
+	attempt := deliveryAttempt{Time: time.Now().UTC(), Kind: attemptChallenge}
+	defer func() {
+		s.recordAttempt(hook, attempt, err)
+		span.set("http.status_code", attempt.StatusCode)
+		span.end(err)
+	}()
+
+	challenge, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+
+	err = s.webhookPolicy.checkUrl(hook.Url)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(hook.Url)
+	if err != nil {
+		return err
+	}
+
+	q := u.Query()
+	q.Set("hub.mode", "subscribe")
+	q.Set("hub.topic", "/currencies?base="+hook.BaseCurrency)
+	q.Set("hub.challenge", challenge)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Authorization", hook.Secret)
+	injectTrace(ctx, req.Header)
+
+	start := time.Now()
+	res, err := s.webhookClient.Do(req)
+	attempt.Latency = millis(time.Since(start))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	attempt.StatusCode = res.StatusCode
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxChallengeSize))
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Webhook challenge returned: %d", res.StatusCode)
 	}
+
+	if strings.TrimSpace(string(body)) != challenge {
+		return fmt.Errorf("Webhook did not echo the challenge: %s", hook.Url)
+	}
+
+	return nil
 }
 
-// calls a single webhook
-func (s *Server) callSingleWebhook(hook webhook) (err error) {
-	// creates a "response" using the base currency of the webhook
-	cRes, err := s.createResponse(hook.BaseCurrency)
+// calls a single webhook, the attempt is recorded in the delivery log
+func (s *Server) callSingleWebhook(ctx context.Context, hook webhook) (err error) {
+	ctx, span := startSpan(ctx, "webhook.deliver", spanClient)
+	span.set("webhook.url", hook.Url)
+	span.set("webhook.base_currency", hook.BaseCurrency)
+
+	attempt := deliveryAttempt{Time: time.Now().UTC(), Kind: attemptDelivery}
+	defer func() {
+		s.recordAttempt(hook, attempt, err)
+		span.set("http.status_code", attempt.StatusCode)
+		span.end(err)
+	}()
+	s.rateMutex.RLock()
+	logger := s.webhookLogger(hook).With("version", s.version)
+	s.rateMutex.RUnlock()
+
+	// creates the payload in the format of the webhook
+	payload, contentType, err := s.webhookPayload(hook)
 	if err != nil {
-		log.Println("Error creating data for webhook:", err)
+		logger.Error("Error creating data for webhook", "error", err)
 		return err
 	}
 
-	// encodes the "response" to be used as a request payload
-	data := &bytes.Buffer{}
-	err = json.NewEncoder(data).Encode(&cRes)
+	// wraps the payload as a CloudEvent if requested
+	header := http.Header{"Content-Type": {contentType}}
+	if mode := s.cloudEventsMode(hook); mode != "" {
+		payload, header, err = s.ratesEvent(hook.BaseCurrency).encode(mode, payload, contentType)
+		if err != nil {
+			logger.Error("Error creating event for webhook", "error", err)
+			return err
+		}
+	}
+	attempt.PayloadHash = payloadHash(payload)
+
+	// checks the URL again, the policy is also applied to every connection
+	err = s.webhookPolicy.checkUrl(hook.Url)
 	if err != nil {
-		log.Println("Error creating data for webhook:", err)
+		logger.Warn("Webhook rejected", "error", err)
 		return err
 	}
 
 	// creates a new request using the payload data and the webhook URL
-	req, err := http.NewRequest(http.MethodPost, hook.Url, data)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.Url, bytes.NewReader(payload))
 	if err != nil {
-		log.Println("Error creating request:", err)
+		logger.Error("Error creating request", "error", err)
 		return err
 	}
 
-	// sets headers for content type and authorization with the
-	// webhook secret
-	req.Header.Add("Content-Type", "application/json")
+	// sets headers for content type (and the event attributes) and
+	// authorization with the webhook secret
+	for name, values := range header {
+		req.Header[name] = values
+	}
 	req.Header.Add("Authorization", hook.Secret)
+	injectTrace(ctx, req.Header)
 
 	// make the request, log return code or errors
-	res, err := http.DefaultClient.Do(req)
+	start := time.Now()
+	res, err := s.webhookClient.Do(req)
+	attempt.Latency = millis(time.Since(start))
 	if err != nil {
-		log.Println("Webhook call error:", err)
+		logger.Warn("Webhook call error", "latency_ms", attempt.Latency, "error", err)
 		return err
 	} else {
-		log.Printf("Webhook return code: %d\n", res.StatusCode)
+		logger.Info("Webhook called", "status", res.StatusCode, "latency_ms", attempt.Latency)
 	}
+	res.Body.Close()
+	attempt.StatusCode = res.StatusCode
 
 	s.webhookTriggers.Add(1)
 
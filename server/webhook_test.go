@@ -1,8 +1,12 @@
 package server
 
 import (
+	"context"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 )
 
 const (
@@ -14,8 +18,9 @@ var (
 )
 
 type webhookServer struct {
-	secret string
-	calls  int
+	secret     string
+	calls      int
+	challenges int
 }
 
 func (s *webhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -24,6 +29,13 @@ func (s *webhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// echo the challenge to verify the webhook
+	if r.Method == http.MethodGet {
+		s.challenges++
+		w.Write([]byte(r.URL.Query().Get("hub.challenge")))
+		return
+	}
+
 	s.calls++
 }
 
@@ -39,15 +51,20 @@ func TestWebhookRegister(t *testing.T) {
 		Secret:       "verysecret",
 		Url:          "http://" + webhookServerAddr,
 	})
-	expect(t, r, http.StatusOK, true, nil)
+	var hook webhook
+	expect(t, r, http.StatusOK, true, &hook)
 	if count+1 != hookServer.calls {
 		t.Fatal("Expected one call after registration")
 	}
+
+	if hook.Status != webhookActive || hook.Secret != "" {
+		t.Fatal("Unexpected webhook:", hook)
+	}
 }
 
 func TestWebhookCalling(t *testing.T) {
 	count := hookServer.calls
-	server.callWebhooks()
+	server.callWebhooks(context.Background())
 	if count+1 != hookServer.calls {
 		t.Fatal("Expected one call extra")
 	}
@@ -60,10 +77,97 @@ func TestWebhookWrongSecret(t *testing.T) {
 		Secret:       "wrongsecret",
 		Url:          "http://" + webhookServerAddr,
 	})
-	expect(t, r, http.StatusInternalServerError, true, nil)
+	var hook webhook
+	expect(t, r, http.StatusAccepted, true, &hook)
+	if r.Header().Get("Content-Type") != "application/json" {
+		t.Fatal("Unexpected content type:", r.Header()["Content-Type"])
+	}
+
 	if count != hookServer.calls {
 		t.Fatal("Expected no call after registration")
 	}
+
+	if hook.Status != webhookPending {
+		t.Fatal("Expected a pending webhook:", hook)
+	}
+
+	// the verified webhook is kept until the new one passes the challenge
+	server.mutex.Lock()
+	active, pending := server.webhooks[hook.Url], server.pending[hook.Url]
+	delete(server.pending, hook.Url)
+	server.mutex.Unlock()
+	if active.Status != webhookActive || active.Secret != "verysecret" || pending.Secret != "wrongsecret" {
+		t.Fatal("Expected the active webhook to be kept:", active, pending)
+	}
+}
+
+func TestWebhookReregister(t *testing.T) {
+	secret, calls := "first", map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != secret {
+			http.Error(w, "", http.StatusForbidden)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			w.Write([]byte(r.URL.Query().Get("hub.challenge")))
+			return
+		}
+
+		calls[r.Header.Get("Authorization")]++
+	}))
+	defer ts.Close()
+	defer func() {
+		server.mutex.Lock()
+		delete(server.webhooks, ts.URL)
+		server.mutex.Unlock()
+	}()
+
+	r := fireReq("/webhook", http.MethodPost, webhook{BaseCurrency: "DKK", Secret: "first", Url: ts.URL})
+	expect(t, r, http.StatusOK, true, nil)
+
+	// another registration of the URL doesn't pass the challenge
+	r = fireReq("/webhook", http.MethodPost, webhook{BaseCurrency: "USD", Secret: "second", Url: ts.URL})
+	expect(t, r, http.StatusAccepted, true, nil)
+
+	server.mutex.Lock()
+	hook := server.webhooks[ts.URL]
+	server.mutex.Unlock()
+	if hook.Status != webhookActive || hook.Secret != "first" || hook.BaseCurrency != "DKK" {
+		t.Fatal("Expected the webhook to be kept:", hook)
+	}
+
+	// the re-registration is listed with its own log
+	var status webhookStatus
+	r = fireReqHeaders("/admin/webhooks?url="+url.QueryEscape(ts.URL), http.MethodGet, nil, adminHeaders)
+	expect(t, r, http.StatusOK, true, &status)
+	if status.Status != webhookActive || len(status.Attempts) != 2 || status.Attempts[0].Error != "" || status.Attempts[1].Error != "" {
+		t.Fatal("Unexpected log of the webhook:", status)
+	}
+
+	if status.Pending == nil || status.Pending.Status != webhookPending || status.Pending.BaseCurrency != "USD" ||
+		len(status.Pending.Attempts) != 1 || status.Pending.Attempts[0].StatusCode != http.StatusForbidden {
+		t.Fatal("Unexpected re-registration:", status.Pending)
+	}
+
+	// once it passes it replaces the webhook, which isn't called anymore
+	secret, first := "second", hook
+	server.callWebhooks(context.Background())
+
+	server.mutex.Lock()
+	hook, pending := server.webhooks[ts.URL], len(server.pending)
+	server.mutex.Unlock()
+	if hook.Status != webhookActive || hook.Secret != "second" || hook.BaseCurrency != "USD" || pending != 0 {
+		t.Fatal("Expected the webhook to be replaced:", hook, pending)
+	}
+
+	if calls["first"] != 1 || calls["second"] != 1 {
+		t.Fatal("Unexpected calls:", calls)
+	}
+
+	if len(server.webhookAttempts(first)) != 0 || len(server.webhookAttempts(hook)) != 3 {
+		t.Fatal("Expected the log of the re-registration only")
+	}
 }
 
 func TestWebhookWrongBase(t *testing.T) {
@@ -79,3 +183,62 @@ func TestWebhookGet(t *testing.T) {
 	r := fireReq("/webhook", http.MethodGet, nil)
 	expect(t, r, http.StatusNotFound, true, nil)
 }
+
+func TestWebhookChallenge(t *testing.T) {
+	echo, challenges, calls := false, 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			calls++
+			return
+		}
+
+		challenges++
+		if r.URL.Query().Get("hub.mode") != "subscribe" || r.URL.Query().Get("hub.topic") != "/currencies?base=USD" {
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+
+		if echo {
+			w.Write([]byte(r.URL.Query().Get("hub.challenge")))
+		}
+	}))
+	defer ts.Close()
+	defer func() {
+		server.mutex.Lock()
+		delete(server.webhooks, ts.URL+"/hook?id=1")
+		server.mutex.Unlock()
+	}()
+
+	// a receiver ignoring the challenge stays pending and is not called
+	r := fireReq("/webhook", http.MethodPost, webhook{BaseCurrency: "USD", Url: ts.URL + "/hook?id=1"})
+	expect(t, r, http.StatusAccepted, true, nil)
+
+	server.callWebhooks(context.Background())
+	if challenges != 2 || calls != 0 {
+		t.Fatal("Expected only challenges:", challenges, calls)
+	}
+
+	// it is activated and called once it echoes
+	echo = true
+	server.callWebhooks(context.Background())
+	if calls != 1 || server.webhooks[ts.URL+"/hook?id=1"].Status != webhookActive {
+		t.Fatal("Expected the webhook to be activated:", calls)
+	}
+
+	server.callWebhooks(context.Background())
+	if challenges != 3 || calls != 2 {
+		t.Fatal("Expected no more challenges:", challenges, calls)
+	}
+}
+
+func TestWebhookPendingExpiry(t *testing.T) {
+	url := "http://" + webhookServerAddr + "/expired"
+	server.mutex.Lock()
+	server.webhooks[url] = webhook{BaseCurrency: "DKK", Url: url, Status: webhookPending, registered: time.Now().Add(-pendingWebhookTTL - time.Minute)}
+	server.mutex.Unlock()
+
+	server.callWebhooks(context.Background())
+	if _, found := server.webhooks[url]; found {
+		t.Fatal("Expected the pending webhook to be dropped")
+	}
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	attemptChallenge = "challenge" // a challenge of a pending webhook
+	attemptDelivery  = "delivery"  // a call with the rates
+
+	maxAttempts               = 50  // attempts kept per webhook
+	defaultWebhookMaxFailures = "5" // consecutive failures before a webhook is disabled
+)
+
+// the key of the log of a single registration, a re-registration of the
+// URL has a log of its own
+type registrationKey struct {
+	url        string
+	registered int64
+}
+
+// returns the key of the log of the webhook
+func attemptKey(hook webhook) registrationKey {
+	return registrationKey{url: hook.Url, registered: hook.registered.UnixNano()}
+}
+
+// a single call of a webhook
+type deliveryAttempt struct {
+	Time        time.Time `json:"time"`
+	Kind        string    `json:"kind"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	Latency     float64   `json:"latency_ms"`
+	Error       string    `json:"error,omitempty"`
+	PayloadHash string    `json:"payload_hash,omitempty"`
+}
+
+// struct for the webhook status, the secret is never returned
+type webhookStatus struct {
+	BaseCurrency string            `json:"base_currency"`
+	Url          string            `json:"url"`
+	Status       string            `json:"status"`
+	Failures     int               `json:"failures"`
+	Registered   time.Time         `json:"registered"`
+	LastAttempt  *deliveryAttempt  `json:"last_attempt,omitempty"`
+	Attempts     []deliveryAttempt `json:"attempts,omitempty"`
+	Pending      *webhookStatus    `json:"pending,omitempty"` // the re-registration waiting for the challenge
+}
+
+// struct for the notification sent when a webhook is disabled
+type disabledNotification struct {
+	Event     string    `json:"event"`
+	Url       string    `json:"url"`
+	Failures  int       `json:"failures"`
+	LastError string    `json:"last_error"`
+	Time      time.Time `json:"time"`
+}
+
+// returns the duration in milliseconds
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// returns the hex encoded SHA-256 hash of the payload
+func payloadHash(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// records the attempt with its error in the log of the webhook, only the
+// last attempts are kept
+func (s *Server) recordAttempt(hook webhook, attempt deliveryAttempt, err error) {
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	s.metrics.observeDelivery(attempt.Kind, err, attempt.Latency)
+
+	s.attemptMutex.Lock()
+	defer s.attemptMutex.Unlock()
+
+	key := attemptKey(hook)
+	attempts := append(s.attempts[key], attempt)
+	if len(attempts) > maxAttempts {
+		attempts = attempts[len(attempts)-maxAttempts:]
+	}
+
+	s.attempts[key] = attempts
+}
+
+// returns a copy of the attempts of the webhook, oldest first
+func (s *Server) webhookAttempts(hook webhook) []deliveryAttempt {
+	s.attemptMutex.Lock()
+	defer s.attemptMutex.Unlock()
+
+	return append([]deliveryAttempt{}, s.attempts[attemptKey(hook)]...)
+}
+
+// clears the log of the webhook
+func (s *Server) clearAttempts(hook webhook) {
+	s.attemptMutex.Lock()
+	defer s.attemptMutex.Unlock()
+
+	delete(s.attempts, attemptKey(hook))
+}
+
+// Updates the consecutive failures of the webhook after a delivery.
+// Disables the webhook if it failed too often and sends a notification.
+// Must be called while holding the lock.
+func (s *Server) updateHealth(hook webhook, err error) webhook {
+	if err == nil {
+		hook.Failures = 0
+		return hook
+	}
+
+	hook.Failures++
+	if s.webhookMaxFailures > 0 && hook.Failures >= s.webhookMaxFailures {
+		hook.Status = webhookDisabled
+		s.webhookDisables.Add(1)
+		s.webhookLogger(hook).Warn("Webhook disabled", "failures", hook.Failures, "error", err)
+
+		go s.notifyDisabled(disabledNotification{
+			Event:     "webhook.disabled",
+			Url:       hook.Url,
+			Failures:  hook.Failures,
+			LastError: err.Error(),
+			Time:      time.Now().UTC(),
+		})
+	}
+
+	return hook
+}
+
+// posts the notification to the notification URL if configured
+func (s *Server) notifyDisabled(n disabledNotification) {
+	if s.notifyUrl == "" {
+		return
+	}
+	logger := s.logger.With("component", "webhooks", "notify_url", s.notifyUrl, "url", n.Url)
+
+	data, err := json.Marshal(n)
+	if err != nil {
+		logger.Error("Error creating notification", "error", err)
+		return
+	}
+
+	// wraps the notification as a CloudEvent if enabled
+	header := http.Header{"Content-Type": {"application/json"}}
+	if s.cloudEvents != "" {
+		id, _ := randomHex(8)
+		event := newCloudEvent(webhookDisabledEvent, "/webhooks", id, n.Url)
+		data, header, err = event.encode(s.cloudEvents, data, "application/json")
+		if err != nil {
+			logger.Error("Error creating notification event", "error", err)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.notifyUrl, bytes.NewReader(data))
+	if err != nil {
+		logger.Error("Error creating notification", "error", err)
+		return
+	}
+	req.Header = header
+
+	res, err := fetchClient.Do(req)
+	if err != nil {
+		logger.Warn("Notification error", "error", err)
+		return
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		logger.Warn("Notification failed", "status", res.StatusCode)
+	}
+}
+
+// returns the status of the webhook and its pending re-registration if
+// there is one, with all attempts if requested
+func (s *Server) webhookStatus(hook webhook, pending webhook, all bool) webhookStatus {
+	status := webhookStatus{
+		BaseCurrency: hook.BaseCurrency,
+		Url:          hook.Url,
+		Status:       hook.Status,
+		Failures:     hook.Failures,
+		Registered:   hook.registered.UTC(),
+	}
+
+	attempts := s.webhookAttempts(hook)
+	if len(attempts) > 0 {
+		status.LastAttempt = &attempts[len(attempts)-1]
+	}
+
+	if all {
+		status.Attempts = attempts
+	}
+
+	if pending.Url != "" {
+		p := s.webhookStatus(pending, webhook{}, all)
+		status.Pending = &p
+	}
+
+	return status
+}
+
+// Handles the webhook status and delivery logs (/admin/webhooks)
+func (s *Server) webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	url := r.URL.Query().Get("url")
+	if r.Method == http.MethodGet {
+		// copy the webhooks, the lock is not held while responding
+		s.mutex.Lock()
+		hook, found := s.webhooks[url]
+		hooks := make([]webhook, 0, len(s.webhooks))
+		for _, hook := range s.webhooks {
+			hooks = append(hooks, hook)
+		}
+		pending := make(map[string]webhook, len(s.pending))
+		for url, hook := range s.pending {
+			pending[url] = hook
+		}
+		s.mutex.Unlock()
+
+		// GET - the log of a single webhook
+		if url != "" {
+			if !found {
+				http.NotFound(w, r)
+				return
+			}
+
+			s.respondJson(w, s.webhookStatus(hook, pending[url], true), nil)
+			return
+		}
+
+		// GET - the status of all webhooks
+		list := []webhookStatus{}
+		for _, hook := range hooks {
+			list = append(list, s.webhookStatus(hook, pending[hook.Url], false))
+		}
+
+		sort.Slice(list, func(i, j int) bool { return list[i].Url < list[j].Url })
+		s.respondJson(w, list, nil)
+	} else if r.Method == http.MethodDelete {
+		// DELETE - remove the webhook, its pending re-registration and their
+		// logs
+		s.mutex.Lock()
+		hook, found := s.webhooks[url]
+		pending, isPending := s.pending[url]
+		delete(s.webhooks, url)
+		delete(s.pending, url)
+		if found {
+			s.clearAttempts(hook)
+		}
+		if isPending {
+			s.clearAttempts(pending)
+		}
+		s.mutex.Unlock()
+
+		if !found && !isPending {
+			http.NotFound(w, r)
+			return
+		}
+
+		requestLogger(r).Info("Webhook removed", "url", url)
+	} else {
+		http.Error(w, "", http.StatusBadRequest)
+	}
+}
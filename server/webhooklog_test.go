@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestRecordAttemptBounded(t *testing.T) {
+	hook := webhook{Url: "http://bounded.example.com", registered: time.Now()}
+	defer server.clearAttempts(hook)
+
+	for i := 0; i < maxAttempts+10; i++ {
+		server.recordAttempt(hook, deliveryAttempt{StatusCode: i}, nil)
+	}
+
+	// another registration of the URL has its own log
+	if len(server.webhookAttempts(webhook{Url: hook.Url})) != 0 {
+		t.Fatal("Expected an empty log of another registration")
+	}
+
+	attempts := server.webhookAttempts(hook)
+	if len(attempts) != maxAttempts || attempts[0].StatusCode != 10 {
+		t.Fatal("Unexpected attempts:", len(attempts), attempts[0])
+	}
+}
+
+func TestWebhookAutoDisable(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(r.URL.Query().Get("hub.challenge")))
+			return
+		}
+
+		calls++
+		http.Error(w, "", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	notifications := make(chan disabledNotification, 1)
+	notify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n disabledNotification
+		json.NewDecoder(r.Body).Decode(&n)
+		notifications <- n
+	}))
+	defer notify.Close()
+
+	defer func(u string) { server.notifyUrl = u }(server.notifyUrl)
+	server.notifyUrl = notify.URL
+
+	hookUrl := ts.URL + "/failing"
+	r := fireReq("/webhook", http.MethodPost, webhook{BaseCurrency: "DKK", Url: hookUrl})
+	var hook webhook
+	expect(t, r, http.StatusOK, true, &hook)
+	if hook.Status != webhookActive || hook.Failures != 1 {
+		t.Fatal("Unexpected webhook:", hook)
+	}
+
+	for i := 1; i < server.webhookMaxFailures; i++ {
+		server.callWebhooks(context.Background())
+	}
+
+	if server.webhooks[hookUrl].Status != webhookDisabled || calls != server.webhookMaxFailures {
+		t.Fatal("Expected the webhook to be disabled:", server.webhooks[hookUrl], calls)
+	}
+
+	select {
+	case n := <-notifications:
+		if n.Event != "webhook.disabled" || n.Url != hookUrl || n.Failures != server.webhookMaxFailures {
+			t.Fatal("Unexpected notification:", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a notification")
+	}
+
+	server.callWebhooks(context.Background())
+	if calls != server.webhookMaxFailures {
+		t.Fatal("Expected no calls to a disabled webhook")
+	}
+
+	// the log has the challenge and every delivery
+	var status webhookStatus
+	r = fireReqHeaders("/admin/webhooks?url="+url.QueryEscape(hookUrl), http.MethodGet, nil, adminHeaders)
+	expect(t, r, http.StatusOK, true, &status)
+	if status.Status != webhookDisabled || len(status.Attempts) != server.webhookMaxFailures+1 {
+		t.Fatal("Unexpected status:", status)
+	}
+
+	first, last := status.Attempts[0], status.Attempts[len(status.Attempts)-1]
+	if first.Kind != attemptChallenge || first.StatusCode != http.StatusOK {
+		t.Fatal("Unexpected challenge attempt:", first)
+	}
+
+	if last.Kind != attemptDelivery || last.StatusCode != http.StatusInternalServerError || last.Error == "" || len(last.PayloadHash) != 64 {
+		t.Fatal("Unexpected delivery attempt:", last)
+	}
+
+	// the list has the last attempt only
+	var list []webhookStatus
+	r = fireReqHeaders("/admin/webhooks", http.MethodGet, nil, adminHeaders)
+	expect(t, r, http.StatusOK, true, &list)
+	found := false
+	for _, s := range list {
+		if s.Url == hookUrl {
+			found = s.LastAttempt != nil && s.Attempts == nil
+		}
+	}
+
+	if !found {
+		t.Fatal("Expected the webhook in the list:", list)
+	}
+
+	// re-registering enables the webhook again
+	r = fireReq("/webhook", http.MethodPost, webhook{BaseCurrency: "DKK", Url: hookUrl})
+	expect(t, r, http.StatusOK, true, &hook)
+	if hook.Status != webhookActive || hook.Failures != 1 {
+		t.Fatal("Expected an active webhook:", hook)
+	}
+
+	server.mutex.Lock()
+	hook = server.webhooks[hookUrl]
+	server.mutex.Unlock()
+
+	r = fireReqHeaders("/admin/webhooks?url="+url.QueryEscape(hookUrl), http.MethodDelete, nil, adminHeaders)
+	expect(t, r, http.StatusOK, true, nil)
+	if _, found := server.webhooks[hookUrl]; found || len(server.webhookAttempts(hook)) != 0 {
+		t.Fatal("Expected the webhook to be removed")
+	}
+}
+
+func TestWebhookStatusUnauthorized(t *testing.T) {
+	r := fireReq("/admin/webhooks", http.MethodGet, nil)
+	expect(t, r, http.StatusUnauthorized, true, nil)
+
+	r = fireReqHeaders("/admin/webhooks?url="+url.QueryEscape(fmt.Sprintf("http://%s/unknown", webhookServerAddr)), http.MethodGet, nil, adminHeaders)
+	expect(t, r, http.StatusNotFound, true, nil)
+}